@@ -1,14 +1,132 @@
 package app
 
 import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
 	"github.com/dagimg-dot/gitsnip/internal/app/downloader"
 	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/semver"
 )
 
 func Download(opts model.DownloadOptions) error {
+	if ok, err := checkExisting(&opts); !ok || err != nil {
+		return err
+	}
+
+	// Version constraints are narrowed down to a single tag, which the
+	// downloaders then resolve like any other ref.
+	if semver.IsConstraint(opts.Ref) {
+		tag, err := downloader.ResolveVersionConstraint(opts)
+		if err != nil {
+			return err
+		}
+		opts.Ref = tag
+	}
+
+	sink, err := newSink(opts)
+	if err != nil {
+		return err
+	}
+	// Paths that differ only in case would overwrite each other on
+	// case-insensitive filesystems and in archives extracted there.
+	sink = output.WithNameCheck(sink)
+	sink = output.WithSymlinks(sink, opts.Symlinks, opts.Quiet)
+	opts.Sink = sink
+
 	dl, err := downloader.GetDownloader(opts)
 	if err != nil {
+		sink.Abort()
+		return err
+	}
+	if err := dl.Download(); err != nil {
+		sink.Abort()
 		return err
 	}
-	return dl.Download()
+	return sink.Close()
+}
+
+// newSink sets up the output. A single path streamed to stdout is written
+// as its raw bytes when it is a file.
+func newSink(opts model.DownloadOptions) (output.Sink, error) {
+	switch {
+	case opts.OutputFile == output.Stdout:
+		return output.NewStream(os.Stdout, opts.OutputFormat, len(opts.Targets()) == 1)
+	case opts.OutputFile != "":
+		return output.NewArchive(opts.OutputFormat, opts.OutputFile)
+	}
+
+	var roots []string
+	for _, target := range opts.Targets() {
+		roots = append(roots, target.OutputDir)
+	}
+	return output.NewDirSink(opts.OnConflict, roots, opts.Quiet), nil
+}
+
+// checkExisting applies the fail and skip policies to outputs that already
+// have content. Skipped paths are dropped from opts, ok is false when none
+// is left.
+func checkExisting(opts *model.DownloadOptions) (ok bool, err error) {
+	if opts.OnConflict != output.ConflictFail && opts.OnConflict != output.ConflictSkip {
+		return true, nil
+	}
+	if opts.OutputFile == output.Stdout {
+		return true, nil
+	}
+
+	// An archive is a single output for all paths.
+	if opts.OutputFile != "" {
+		if !output.Occupied(opts.OutputFile) {
+			return true, nil
+		}
+		if opts.OnConflict == output.ConflictFail {
+			return false, output.ExistsError(opts.OutputFile)
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Skipping, %s already exists\n", opts.OutputFile)
+		}
+		return false, nil
+	}
+
+	var remaining []model.PathSpec
+	for _, target := range opts.Targets() {
+		existing := conflictPath(target)
+		if !output.Occupied(existing) {
+			remaining = append(remaining, target)
+			continue
+		}
+		if opts.OnConflict == output.ConflictFail {
+			return false, output.ExistsError(existing)
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Skipping %s, %s already exists\n", target.Subdir, existing)
+		}
+	}
+
+	switch len(remaining) {
+	case 0:
+		return false, nil
+	case 1:
+		opts.Paths = nil
+		opts.Subdir, opts.OutputDir = remaining[0].Subdir, remaining[0].OutputDir
+	default:
+		opts.Paths = remaining
+	}
+	return true, nil
+}
+
+// conflictPath returns the output whose content conflicts with target. A
+// file downloaded into an existing directory is saved inside it, so that
+// directory only conflicts through the file's name. Whether target is a
+// file is not known yet; the sink checks the directory itself when target
+// turns out to be a folder.
+func conflictPath(target model.PathSpec) string {
+	if info, err := os.Stat(target.OutputDir); err == nil && info.IsDir() {
+		return filepath.Join(target.OutputDir, path.Base(strings.Trim(target.Subdir, "/")))
+	}
+	return target.OutputDir
 }
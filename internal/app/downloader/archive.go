@@ -0,0 +1,463 @@
+package downloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/gitproto"
+	"github.com/dagimg-dot/gitsnip/internal/app/gitutil"
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/pathfilter"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+	"github.com/dagimg-dot/gitsnip/internal/util"
+)
+
+// archiveTimeout bounds the wait for the response headers of an archive and
+// for each read of its body.
+var archiveTimeout = util.DefaultTimeout
+
+// archiveSource describes how to request a gzipped tarball of the whole
+// repository from a provider. provider is the name used in error messages.
+type archiveSource struct {
+	provider string
+	request  func(opts model.DownloadOptions) (*http.Request, error)
+}
+
+func NewArchiveDownloader(opts model.DownloadOptions, source archiveSource) Downloader {
+	return &archiveDownloader{
+		opts:   opts,
+		client: util.NewHTTPClient(opts.Token),
+		source: source,
+	}
+}
+
+// archiveDownloader fetches the repository as a single tarball and extracts
+// only the entries below Subdir while streaming, so neither git nor one API
+// call per file is needed.
+type archiveDownloader struct {
+	opts   model.DownloadOptions
+	client *http.Client
+	source archiveSource
+	// gitmodulesContent is the commit's .gitmodules, kept from the archive
+	// for RecurseSubmodules.
+	gitmodulesContent []byte
+}
+
+func (a *archiveDownloader) gitmodules() []byte {
+	return a.gitmodulesContent
+}
+
+func (a *archiveDownloader) Download() error {
+	if a.opts.Ref != "" {
+		commit, err := a.resolveCommit()
+		if err != nil {
+			return err
+		}
+		// The archive is requested for the exact commit.
+		a.opts.Branch = commit
+		if !a.opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Resolved %s to commit %s\n", a.opts.Ref, commit)
+		}
+	}
+
+	req, err := a.source.request(a.opts)
+	if err != nil {
+		return err
+	}
+
+	if !a.opts.Quiet {
+		if a.opts.Ref != "" {
+			fmt.Fprintf(os.Stderr, "Downloading %s from %s (ref: %s) using archive...\n",
+				targetsLabel(a.opts), a.opts.RepoURL, a.opts.Ref)
+		} else if a.opts.Branch == "" {
+			fmt.Fprintf(os.Stderr, "Downloading %s from %s (default branch) using archive...\n",
+				targetsLabel(a.opts), a.opts.RepoURL)
+		} else {
+			fmt.Fprintf(os.Stderr, "Downloading %s from %s (branch: %s) using archive...\n",
+				targetsLabel(a.opts), a.opts.RepoURL, a.opts.Branch)
+		}
+	}
+
+	// Archives can be large, so the overall timeout of the API client
+	// gives way to one for the response headers and one for each read of
+	// the streamed body.
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = archiveTimeout
+	client := *a.client
+	client.Timeout = 0
+	client.Transport = transport
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: fmt.Sprintf("Failed to connect to %s", a.source.provider),
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return errors.ParseProviderAPIError(a.source.provider, resp.StatusCode, bodyStr)
+	}
+
+	body := newIdleReader(resp.Body, archiveTimeout, cancel)
+	defer body.stop()
+
+	targets := a.opts.Targets()
+	extracted, err := a.extract(body, targets)
+	if body.stalled() {
+		return &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: fmt.Sprintf("The archive download from %s stalled", a.source.provider),
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, target := range targets {
+		if extracted[i] == 0 {
+			return pathNotFoundError(target.Subdir)
+		}
+	}
+
+	if !a.opts.Quiet {
+		fmt.Fprintln(os.Stderr, "Download completed successfully.")
+	}
+	return nil
+}
+
+// extract writes every archive entry below the Subdir of a target to its
+// OutputDir, all targets in one pass over the stream. The top-level directory
+// that providers wrap archives in is stripped. When Subdir names a file, only
+// that file is written. It returns the number of entries written per target.
+func (a *archiveDownloader) extract(r io.Reader, targets []model.PathSpec) ([]int, error) {
+	extracted := make([]int, len(targets))
+
+	// Each target may publish its own .gitsnipignore. Entries that sort
+	// before it in its folder are held back until it has been read, their
+	// content waits in a temporary file.
+	var spool spoolFile
+	defer spool.remove()
+	filters := make([]*pathfilter.Filter, len(targets))
+	ignoreSeen := make([]bool, len(targets))
+	held := make([][]heldEntry, len(targets))
+	for i := range targets {
+		filters[i] = a.opts.Filter
+		ignoreSeen[i] = a.opts.NoUpstreamIgnore
+	}
+
+	extractHeld := func(i int) error {
+		for _, entry := range held[i] {
+			written, err := a.extractEntry(entry.content, entry.header, entry.name, targets[i], filters[i])
+			if err != nil {
+				return err
+			}
+			if written {
+				extracted[i]++
+			}
+		}
+		held[i] = nil
+		return nil
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return extracted, fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return extracted, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		// Names are checked as stored, cleaning first would turn
+		// "top/../../x" into a name below the output.
+		_, name, found := strings.Cut(strings.TrimPrefix(header.Name, "./"), "/")
+		if !found || strings.Trim(name, "/") == "" {
+			continue
+		}
+		if err := safepath.Check(name); err != nil {
+			return extracted, err
+		}
+		name = path.Clean(name)
+
+		var matches []int
+		for i, target := range targets {
+			if _, ok := archiveRelPath(name, strings.Trim(target.Subdir, "/")); ok {
+				matches = append(matches, i)
+			}
+		}
+
+		// The stream can be read only once. An entry wanted by overlapping
+		// paths is spooled, the .gitmodules kept for the submodule lookup.
+		var buffered io.ReaderAt
+		var size int64
+		switch {
+		case a.opts.RecurseSubmodules && name == gitmodulesFile && header.Typeflag == tar.TypeReg:
+			if a.gitmodulesContent, err = io.ReadAll(tr); err != nil {
+				return extracted, fmt.Errorf("failed to read archive: %w", err)
+			}
+			buffered, size = bytes.NewReader(a.gitmodulesContent), int64(len(a.gitmodulesContent))
+		case len(matches) > 1:
+			section, err := spool.add(tr)
+			if err != nil {
+				return extracted, err
+			}
+			buffered, size = section, section.Size()
+		}
+
+		for _, i := range matches {
+			var content io.Reader = tr
+			if buffered != nil {
+				content = io.NewSectionReader(buffered, 0, size)
+			}
+
+			relPath, _ := archiveRelPath(name, strings.Trim(targets[i].Subdir, "/"))
+			if !ignoreSeen[i] && relPath != "." {
+				switch {
+				case relPath == upstreamIgnoreFile && header.Typeflag == tar.TypeReg:
+					ignore, err := io.ReadAll(content)
+					if err != nil {
+						return extracted, fmt.Errorf("failed to read archive: %w", err)
+					}
+					filters[i] = upstreamFilter(filters[i], targets[i].Subdir, ignore, a.opts.Quiet)
+					ignoreSeen[i] = true
+					if err := extractHeld(i); err != nil {
+						return extracted, err
+					}
+					continue
+				case sortsBeforeIgnore(relPath, header.Typeflag == tar.TypeDir):
+					section, ok := content.(*io.SectionReader)
+					if !ok {
+						if section, err = spool.add(content); err != nil {
+							return extracted, err
+						}
+					}
+					held[i] = append(held[i], heldEntry{header: header, name: name, content: section})
+					continue
+				default:
+					// Archives list a folder in git tree order, the
+					// .gitsnipignore can no longer follow.
+					ignoreSeen[i] = true
+					if err := extractHeld(i); err != nil {
+						return extracted, err
+					}
+				}
+			}
+
+			written, err := a.extractEntry(content, header, name, targets[i], filters[i])
+			if err != nil {
+				return extracted, err
+			}
+			if written {
+				extracted[i]++
+			}
+		}
+	}
+
+	for i := range targets {
+		if err := extractHeld(i); err != nil {
+			return extracted, err
+		}
+	}
+	return extracted, nil
+}
+
+// heldEntry is an archive entry kept back until the .gitsnipignore of its
+// target is known.
+type heldEntry struct {
+	header  *tar.Header
+	name    string
+	content *io.SectionReader
+}
+
+// spoolFile buffers archive entries in a temporary file, created on first
+// use, so large entries do not have to fit in memory.
+type spoolFile struct {
+	file *os.File
+	size int64
+}
+
+// add appends the content of r and returns a reader over it.
+func (s *spoolFile) add(r io.Reader) (*io.SectionReader, error) {
+	if s.file == nil {
+		file, err := os.CreateTemp("", "gitsnip-archive-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		s.file = file
+	}
+	n, err := io.Copy(s.file, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer archive entry: %w", err)
+	}
+	section := io.NewSectionReader(s.file, s.size, n)
+	s.size += n
+	return section, nil
+}
+
+func (s *spoolFile) remove() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+}
+
+// sortsBeforeIgnore reports whether relPath comes before the .gitsnipignore
+// of its folder in git tree order, which compares directories by their name
+// with a trailing slash.
+func sortsBeforeIgnore(relPath string, isDir bool) bool {
+	top, _, nested := strings.Cut(relPath, "/")
+	if nested || isDir {
+		top += "/"
+	}
+	return top < upstreamIgnoreFile
+}
+
+// extractEntry writes a single directory, regular file or symlink entry for
+// target, leaving out what filter does not match. written is false for other
+// entry types.
+func (a *archiveDownloader) extractEntry(content io.Reader, header *tar.Header, name string, target model.PathSpec, filter *pathfilter.Filter) (written bool, err error) {
+	relPath, _ := archiveRelPath(name, strings.Trim(target.Subdir, "/"))
+	outputDir := target.OutputDir
+	targetPath, err := safepath.Join(outputDir, relPath)
+	if err != nil {
+		return false, err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		// The directory counts as found even when the filter leaves it out.
+		if relPath == "." || filter.Match(relPath, true) {
+			if err := a.opts.Sink.MkdirAll(targetPath); err != nil {
+				return false, fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+			}
+		}
+		return true, nil
+	case tar.TypeReg:
+		if relPath == "." {
+			if targetPath, err = singleFilePath(a.opts.Sink, outputDir, name); err != nil {
+				return false, err
+			}
+		} else if !filter.Match(relPath, false) {
+			return false, nil
+		}
+		if !a.opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Extracting %s\n", name)
+		}
+		// Mirror git, which only tracks the executable bit.
+		if err := a.opts.Sink.WriteFile(targetPath, content, header.Mode&0111 != 0); err != nil {
+			return false, err
+		}
+		return true, nil
+	case tar.TypeSymlink:
+		if relPath == "." || a.opts.Symlinks == output.SymlinksSkip || !filter.Match(relPath, false) {
+			return false, nil
+		}
+		if err := writeSymlink(a.opts.Sink, relPath, targetPath, strings.NewReader(header.Linkname)); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// archiveRelPath maps a repository path to its location below prefix. The
+// entry for prefix itself maps to ".".
+func archiveRelPath(name, prefix string) (string, bool) {
+	if prefix == "" {
+		return name, filepath.IsLocal(name)
+	}
+	if name == prefix {
+		return ".", true
+	}
+
+	relPath, found := strings.CutPrefix(name, prefix+"/")
+	if !found || !filepath.IsLocal(relPath) {
+		return "", false
+	}
+	return relPath, true
+}
+
+// resolveCommit resolves Ref to a full commit hash over the git protocol,
+// archive endpoints have no way to report which commit they served.
+func (a *archiveDownloader) resolveCommit() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.opts.GitTimeout())
+	defer cancel()
+
+	client := gitproto.NewClient(gitutil.AuthenticatedURL(a.opts.RepoURL, a.opts.Token, a.opts.Provider))
+	if err := client.Connect(ctx); err != nil {
+		return "", transportError(err)
+	}
+
+	commit, err := client.ResolveCommit(ctx, a.opts.Ref)
+	if stderrors.Is(err, gitproto.ErrRefNotFound) {
+		return "", refNotFoundError(a.opts.Ref)
+	}
+	if err != nil {
+		return "", transportError(err)
+	}
+	return commit, nil
+}
+
+// idleReader cancels a download through cancel when no data arrives for
+// timeout.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newIdleReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *idleReader {
+	ir := &idleReader{r: r, timeout: timeout}
+	ir.timer = time.AfterFunc(timeout, func() {
+		ir.expired.Store(true)
+		cancel()
+	})
+	return ir
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// stalled reports whether the download was cancelled for being idle.
+func (r *idleReader) stalled() bool {
+	return r.expired.Load()
+}
+
+func (r *idleReader) stop() {
+	r.timer.Stop()
+}
@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+// tarEntry is a file, or a directory when its name ends in a slash.
+type tarEntry struct {
+	name    string
+	content string
+}
+
+// newTarGz returns a gzipped tarball of entries wrapped in a top-level
+// directory, as providers serve them.
+func newTarGz(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range append([]tarEntry{{name: ""}}, entries...) {
+		header := &tar.Header{Name: "repo-abc/" + e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		if e.name == "" || strings.HasSuffix(e.name, "/") {
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// listFiles returns the files below dir as slash separated relative paths.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	filepath.WalkDir(dir, func(p string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	sort.Strings(files)
+	return files
+}
+
+func TestArchiveExtractHeldEntries(t *testing.T) {
+	archive := newTarGz(t, []tarEntry{
+		{name: "src/"},
+		{name: "src/.github/"},
+		{name: "src/.github/ci.yml", content: "ci"},
+		{name: "src/.github/debug.log", content: "log"},
+		{name: "src/.gitsnipignore", content: "*.log\n"},
+		{name: "src/main.go", content: "package main"},
+		{name: "src/trace.log", content: "log"},
+		{name: "other.txt", content: "other"},
+	})
+
+	dir := t.TempDir()
+	src, ci := filepath.Join(dir, "src"), filepath.Join(dir, "ci")
+	targets := []model.PathSpec{{Subdir: "src", OutputDir: src}, {Subdir: "src/.github", OutputDir: ci}}
+	sink := output.NewDirSink(output.ConflictFail, []string{src, ci}, true)
+	a := &archiveDownloader{opts: model.DownloadOptions{Paths: targets, Sink: sink, Quiet: true}}
+
+	extracted, err := a.extract(bytes.NewReader(archive), targets)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Entries held back for src are filtered by its .gitsnipignore, the
+	// nested target shares their spooled content but not the filter.
+	want := []string{"ci/ci.yml", "ci/debug.log", "src/.github/ci.yml", "src/main.go"}
+	if got := listFiles(t, dir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("extracted %v, want %v", got, want)
+	}
+	if extracted[0] == 0 || extracted[1] == 0 {
+		t.Errorf("extracted counts = %v, want both targets found", extracted)
+	}
+	if content, _ := os.ReadFile(filepath.Join(ci, "ci.yml")); string(content) != "ci" {
+		t.Errorf("ci.yml = %q, want %q", content, "ci")
+	}
+}
+
+func TestArchiveDownloadStalled(t *testing.T) {
+	defer func(timeout time.Duration) { archiveTimeout = timeout }(archiveTimeout)
+	archiveTimeout = 100 * time.Millisecond
+
+	archive := newTarGz(t, []tarEntry{{name: "src/"}, {name: "src/a.txt", content: "a"}})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive[:len(archive)/2])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "src")
+	source := archiveSource{
+		provider: "Test",
+		request: func(opts model.DownloadOptions) (*http.Request, error) {
+			return http.NewRequest("GET", srv.URL, nil)
+		},
+	}
+	opts := model.DownloadOptions{Subdir: "src", OutputDir: out, Quiet: true, Sink: output.NewDirSink(output.ConflictFail, []string{out}, true)}
+
+	done := make(chan error, 1)
+	go func() { done <- NewArchiveDownloader(opts, source).Download() }()
+	select {
+	case err := <-done:
+		if !stderrors.Is(err, errors.ErrNetworkFailure) {
+			t.Errorf("Download = %v, want a network failure", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Download hangs on a stalled archive")
+	}
+}
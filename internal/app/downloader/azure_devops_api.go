@@ -0,0 +1,519 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+	"github.com/dagimg-dot/gitsnip/internal/util"
+)
+
+const (
+	AzureDevOpsBaseURL    = "https://dev.azure.com"
+	AzureDevOpsAPIVersion = "7.0"
+)
+
+type AzureDevOpsItem struct {
+	ObjectID      string `json:"objectId"`
+	GitObjectType string `json:"gitObjectType"`
+	Path          string `json:"path"`
+	IsFolder      bool   `json:"isFolder"`
+	URL           string `json:"url"`
+}
+
+type azureDevOpsItemList struct {
+	Count int               `json:"count"`
+	Value []AzureDevOpsItem `json:"value"`
+}
+
+// azureDevOpsRepo identifies a repository as organization URL, project and
+// repository name.
+type azureDevOpsRepo struct {
+	baseURL string
+	project string
+	repo    string
+}
+
+func NewAzureDevOpsAPIDownloader(opts model.DownloadOptions) Downloader {
+	return &azureDevOpsAPIDownloader{
+		opts:   opts,
+		client: util.NewHTTPClient(opts.Token),
+	}
+}
+
+type azureDevOpsAPIDownloader struct {
+	opts   model.DownloadOptions
+	client *http.Client
+	// gitmodulesContent is the commit's .gitmodules, read for
+	// RecurseSubmodules.
+	gitmodulesContent []byte
+}
+
+func (a *azureDevOpsAPIDownloader) Download() error {
+	repo, err := parseAzureDevOpsURL(a.opts.RepoURL)
+	if err != nil {
+		return &errors.AppError{
+			Err:     errors.ErrInvalidURL,
+			Message: "Invalid Azure DevOps URL format",
+			Hint:    "URL should be in the format: https://dev.azure.com/org/project/_git/repo",
+		}
+	}
+
+	if a.opts.APIURL != "" {
+		repo.baseURL = strings.TrimSuffix(a.opts.APIURL, "/")
+	}
+
+	if err := resolveBranch(&a.opts, azureDevOpsRefs{a, repo}); err != nil {
+		return err
+	}
+
+	if a.opts.RecurseSubmodules {
+		a.gitmodulesContent, err = readGitmodules(func(ctx context.Context) (io.ReadCloser, error) {
+			return a.openFile(ctx, repo, "/"+gitmodulesFile)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return forEachTarget(&a.opts, func() error {
+		return a.downloadPath(repo)
+	})
+}
+
+func (a *azureDevOpsAPIDownloader) gitmodules() []byte {
+	return a.gitmodulesContent
+}
+
+// downloadPath downloads Subdir, a directory or a single file, to OutputDir.
+func (a *azureDevOpsAPIDownloader) downloadPath(repo azureDevOpsRepo) error {
+	if !a.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloading %s from %s/%s (ref: %s)...\n",
+			a.opts.Subdir, repo.project, repo.repo, a.opts.Branch)
+	}
+
+	items, err := a.getItems(repo, a.opts.Subdir, "Full")
+	if err != nil {
+		return err
+	}
+
+	scopePath := "/" + strings.Trim(a.opts.Subdir, "/")
+	if len(items) == 1 && !items[0].IsFolder && items[0].Path == scopePath {
+		return a.downloadSingleFile(repo, items[0])
+	}
+
+	if err := a.opts.Sink.MkdirAll(a.opts.OutputDir); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	ignorePath := path.Join(scopePath, upstreamIgnoreFile)
+	for _, item := range items {
+		if item.Path == ignorePath && item.GitObjectType == "blob" {
+			err := applyUpstreamIgnore(&a.opts, func(ctx context.Context) (io.ReadCloser, error) {
+				return a.openFile(ctx, repo, ignorePath)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The listing reports no modes, they come from the tree of the folder.
+	var modes map[string]string
+	for _, item := range items {
+		if item.Path == scopePath && item.IsFolder {
+			if modes, err = a.getTreeModes(repo, item.ObjectID, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	var tasks []fileTask
+	for _, item := range items {
+		relPath := strings.TrimPrefix(strings.TrimPrefix(item.Path, scopePath), "/")
+		if relPath == "" {
+			continue
+		}
+		targetPath, err := safepath.Join(a.opts.OutputDir, relPath)
+		if err != nil {
+			return err
+		}
+
+		// The listing is flat, files below excluded folders are
+		// matched through their parents.
+		mode := modes[relPath]
+		if item.IsFolder {
+			if a.opts.Filter.Match(relPath, true) {
+				if err := a.opts.Sink.MkdirAll(targetPath); err != nil {
+					return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+				}
+			}
+		} else if item.GitObjectType == "blob" && mode == gitModeSymlink {
+			if a.opts.Symlinks == output.SymlinksSkip || !a.opts.Filter.Match(relPath, false) {
+				continue
+			}
+			filePath := item.Path
+			tasks = append(tasks, fileTask{
+				repoPath: strings.TrimPrefix(filePath, "/"),
+				fetch: func(ctx context.Context) error {
+					return downloadSymlink(ctx, a.opts.Sink, relPath, targetPath, func(ctx context.Context) (io.ReadCloser, error) {
+						return a.openFile(ctx, repo, filePath)
+					})
+				},
+			})
+		} else if item.GitObjectType == "blob" && a.opts.Filter.Match(relPath, false) {
+			filePath := item.Path
+			executable := mode == gitModeExecutable
+			tasks = append(tasks, fileTask{
+				repoPath: strings.TrimPrefix(filePath, "/"),
+				fetch: func(ctx context.Context) error {
+					return a.downloadFile(ctx, repo, filePath, targetPath, executable)
+				},
+			})
+		}
+	}
+
+	return downloadFiles(tasks, a.opts.Concurrency, a.opts.Quiet)
+}
+
+// downloadSingleFile fetches a file, its mode comes from the tree of the
+// parent folder.
+func (a *azureDevOpsAPIDownloader) downloadSingleFile(repo azureDevOpsRepo, item AzureDevOpsItem) error {
+	targetPath, err := singleFilePath(a.opts.Sink, a.opts.OutputDir, item.Path)
+	if err != nil {
+		return err
+	}
+
+	parent := path.Dir(item.Path)
+	folders, err := a.getItems(repo, parent, "None")
+	if err != nil {
+		return err
+	}
+	var modes map[string]string
+	for _, folder := range folders {
+		if folder.Path == parent && folder.IsFolder {
+			if modes, err = a.getTreeModes(repo, folder.ObjectID, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	executable := modes[path.Base(item.Path)] == gitModeExecutable
+	if err := a.downloadFile(context.Background(), repo, item.Path, targetPath, executable); err != nil {
+		return err
+	}
+
+	if !a.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloaded %s to %s\n", strings.TrimPrefix(item.Path, "/"), targetPath)
+	}
+	return nil
+}
+
+// parseAzureDevOpsURL understands the dev.azure.com and legacy
+// visualstudio.com "_git" URL shapes as well as SSH remotes.
+func parseAzureDevOpsURL(repoURL string) (azureDevOpsRepo, error) {
+	patterns := []struct {
+		pattern *regexp.Regexp
+		baseURL func(matches []string) string
+	}{
+		{
+			regexp.MustCompile(`dev\.azure\.com/([^/]+)/([^/]+)/_git/([^/?#]+?)(?:\.git)?/?$`),
+			func(m []string) string { return AzureDevOpsBaseURL + "/" + m[1] },
+		},
+		{
+			regexp.MustCompile(`([^/@.]+)\.visualstudio\.com/(?:DefaultCollection/)?([^/]+)/_git/([^/?#]+?)(?:\.git)?/?$`),
+			func(m []string) string { return "https://" + m[1] + ".visualstudio.com" },
+		},
+		{
+			regexp.MustCompile(`ssh\.dev\.azure\.com:v3/([^/]+)/([^/]+)/([^/]+?)(?:\.git)?$`),
+			func(m []string) string { return AzureDevOpsBaseURL + "/" + m[1] },
+		},
+	}
+
+	for _, p := range patterns {
+		matches := p.pattern.FindStringSubmatch(repoURL)
+		if matches != nil && len(matches) >= 4 {
+			project, _ := url.PathUnescape(matches[2])
+			repo, _ := url.PathUnescape(matches[3])
+			return azureDevOpsRepo{baseURL: p.baseURL(matches), project: project, repo: repo}, nil
+		}
+	}
+
+	return azureDevOpsRepo{}, fmt.Errorf("URL does not match Azure DevOps repository pattern: %s", repoURL)
+}
+
+// resolveCommit returns the full id of the commit ref points to. The commits
+// endpoint needs to be told the kind of version, so tags, branches and
+// commit ids are tried in the order git rev-parse uses.
+func (a *azureDevOpsAPIDownloader) resolveCommit(repo azureDevOpsRepo, ref string) (string, error) {
+	ref = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(ref, "refs/"), "tags/"), "heads/")
+	versionTypes := []string{"tag", "branch"}
+	if shortCommitPattern.MatchString(ref) || fullCommitPattern.MatchString(ref) {
+		versionTypes = append(versionTypes, "commit")
+	}
+
+	for _, versionType := range versionTypes {
+		query := url.Values{}
+		query.Set("searchCriteria.itemVersion.version", ref)
+		query.Set("searchCriteria.itemVersion.versionType", versionType)
+		query.Set("$top", "1")
+		query.Set("api-version", AzureDevOpsAPIVersion)
+
+		apiURL := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/commits?%s",
+			repo.baseURL, url.PathEscape(repo.project), url.PathEscape(repo.repo), query.Encode())
+
+		req, err := util.NewAzureDevOpsRequest("GET", apiURL, a.opts.Token)
+		if err != nil {
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return "", &errors.AppError{
+				Err:     errors.ErrNetworkFailure,
+				Message: "Failed to connect to Azure DevOps API",
+				Hint:    "Check your internet connection and try again",
+			}
+		}
+
+		var list struct {
+			Value []struct {
+				CommitID string `json:"commitId"`
+			} `json:"value"`
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&list)
+			resp.Body.Close()
+			if err != nil {
+				return "", fmt.Errorf("failed to parse API response: %w", err)
+			}
+			if len(list.Value) > 0 {
+				return list.Value[0].CommitID, nil
+			}
+		case http.StatusUnauthorized, http.StatusForbidden:
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return "", errors.ParseAzureDevOpsAPIError(resp.StatusCode, strings.TrimSpace(string(body)))
+		default:
+			// The version does not exist as this kind, try the next one.
+			resp.Body.Close()
+		}
+	}
+
+	return "", refNotFoundError(ref)
+}
+
+// azureDevOpsRefs resolves refs of one repository for resolveBranch.
+type azureDevOpsRefs struct {
+	d    *azureDevOpsAPIDownloader
+	repo azureDevOpsRepo
+}
+
+func (r azureDevOpsRefs) defaultBranch() (string, error) {
+	return r.d.getDefaultBranch(r.repo)
+}
+
+func (r azureDevOpsRefs) resolveCommit(ref string) (string, error) {
+	return r.d.resolveCommit(r.repo, ref)
+}
+
+// getDefaultBranch reads the default branch from the repository metadata.
+func (a *azureDevOpsAPIDownloader) getDefaultBranch(repo azureDevOpsRepo) (string, error) {
+	apiURL := fmt.Sprintf("%s/%s/_apis/git/repositories/%s?api-version=%s",
+		repo.baseURL, url.PathEscape(repo.project), url.PathEscape(repo.repo), AzureDevOpsAPIVersion)
+
+	req, err := util.NewAzureDevOpsRequest("GET", apiURL, a.opts.Token)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Azure DevOps API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return "", errors.ParseAzureDevOpsAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var meta struct {
+		DefaultBranch string `json:"defaultBranch"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	// Empty repositories have no default branch.
+	if meta.DefaultBranch == "" {
+		return "", &errors.AppError{
+			Err:     errors.ErrPathNotFound,
+			Message: "Repository has no default branch",
+			Hint:    "Use --branch to select a branch",
+		}
+	}
+
+	return strings.TrimPrefix(meta.DefaultBranch, "refs/heads/"), nil
+}
+
+func (a *azureDevOpsAPIDownloader) itemsURL(repo azureDevOpsRepo, query url.Values) string {
+	if a.opts.Branch != "" {
+		versionType := "branch"
+		if a.opts.Ref != "" {
+			// Branch holds the commit Ref was resolved to.
+			versionType = "commit"
+		}
+		query.Set("versionDescriptor.version", a.opts.Branch)
+		query.Set("versionDescriptor.versionType", versionType)
+	}
+	query.Set("api-version", AzureDevOpsAPIVersion)
+
+	return fmt.Sprintf("%s/%s/_apis/git/repositories/%s/items?%s",
+		repo.baseURL, url.PathEscape(repo.project), url.PathEscape(repo.repo), query.Encode())
+}
+
+// getItems lists path and the items below it down to recursionLevel with a
+// single Items API call.
+func (a *azureDevOpsAPIDownloader) getItems(repo azureDevOpsRepo, path, recursionLevel string) ([]AzureDevOpsItem, error) {
+	query := url.Values{}
+	query.Set("scopePath", "/"+strings.Trim(path, "/"))
+	query.Set("recursionLevel", recursionLevel)
+
+	req, err := util.NewAzureDevOpsRequest("GET", a.itemsURL(repo, query), a.opts.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Azure DevOps API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return nil, errors.ParseAzureDevOpsAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var list azureDevOpsItemList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return list.Value, nil
+}
+
+// getTreeModes returns the git mode of the entries of the tree treeID,
+// keyed by their path relative to it.
+func (a *azureDevOpsAPIDownloader) getTreeModes(repo azureDevOpsRepo, treeID string, recursive bool) (map[string]string, error) {
+	apiURL := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/trees/%s?recursive=%t&api-version=%s",
+		repo.baseURL, url.PathEscape(repo.project), url.PathEscape(repo.repo), url.PathEscape(treeID), recursive, AzureDevOpsAPIVersion)
+
+	req, err := util.NewAzureDevOpsRequest("GET", apiURL, a.opts.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Azure DevOps API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return nil, errors.ParseAzureDevOpsAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var tree struct {
+		TreeEntries []struct {
+			RelativePath string `json:"relativePath"`
+			Mode         string `json:"mode"`
+		} `json:"treeEntries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	modes := make(map[string]string, len(tree.TreeEntries))
+	for _, entry := range tree.TreeEntries {
+		modes[strings.Trim(entry.RelativePath, "/")] = entry.Mode
+	}
+	return modes, nil
+}
+
+// openFile requests the raw content of a file.
+func (a *azureDevOpsAPIDownloader) openFile(ctx context.Context, repo azureDevOpsRepo, filePath string) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("path", filePath)
+	query.Set("download", "true")
+	query.Set("$format", "octetStream")
+
+	req, err := util.NewAzureDevOpsRequest("GET", a.itemsURL(repo, query), a.opts.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/octet-stream")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to download file",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return nil, errors.ParseAzureDevOpsAPIError(resp.StatusCode, bodyStr)
+	}
+
+	return resp.Body, nil
+}
+
+// downloadFile saves the file to outputPath in the output sink.
+func (a *azureDevOpsAPIDownloader) downloadFile(ctx context.Context, repo azureDevOpsRepo, filePath, outputPath string, executable bool) error {
+	body, err := a.openFile(ctx, repo, filePath)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return a.opts.Sink.WriteFile(outputPath, body, executable)
+}
@@ -0,0 +1,540 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+	"github.com/dagimg-dot/gitsnip/internal/util"
+)
+
+const (
+	BitbucketAPIBaseURL = "https://api.bitbucket.org/2.0"
+	BitbucketWebBaseURL = "https://bitbucket.org"
+	bitbucketPageLen    = 100
+)
+
+type BitbucketSrcItem struct {
+	Path       string   `json:"path"`
+	Type       string   `json:"type"`
+	Attributes []string `json:"attributes"`
+}
+
+type bitbucketSrcPage struct {
+	Values []BitbucketSrcItem `json:"values"`
+	Next   string             `json:"next"`
+}
+
+func NewBitbucketAPIDownloader(opts model.DownloadOptions) Downloader {
+	return &bitbucketAPIDownloader{
+		opts:   opts,
+		client: util.NewHTTPClient(opts.Token),
+	}
+}
+
+type bitbucketAPIDownloader struct {
+	opts   model.DownloadOptions
+	client *http.Client
+	// commit is the hash Branch points to. The src endpoint cannot address
+	// branches whose name contains a slash.
+	commit string
+	// gitmodulesContent is the commit's .gitmodules, read for
+	// RecurseSubmodules.
+	gitmodulesContent []byte
+}
+
+func (b *bitbucketAPIDownloader) Download() error {
+	workspace, repo, err := parseBitbucketURL(b.opts.RepoURL)
+	if err != nil {
+		return &errors.AppError{
+			Err:     errors.ErrInvalidURL,
+			Message: "Invalid Bitbucket URL format",
+			Hint:    "URL should be in the format: https://bitbucket.org/workspace/repo",
+		}
+	}
+
+	if err := resolveBranch(&b.opts, bitbucketRefs{b, workspace, repo}); err != nil {
+		return err
+	}
+	b.commit, err = b.refCommit(workspace, repo, b.opts.Branch)
+	if err != nil {
+		return err
+	}
+
+	if b.opts.RecurseSubmodules {
+		fileURL := b.srcURL(workspace, repo, gitmodulesFile)
+		b.gitmodulesContent, err = readGitmodules(func(ctx context.Context) (io.ReadCloser, error) {
+			return b.openFile(ctx, fileURL)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return forEachTarget(&b.opts, func() error {
+		return b.downloadPath(workspace, repo)
+	})
+}
+
+func (b *bitbucketAPIDownloader) gitmodules() []byte {
+	return b.gitmodulesContent
+}
+
+// downloadPath downloads Subdir, a directory or a single file, to OutputDir.
+func (b *bitbucketAPIDownloader) downloadPath(workspace, repo string) error {
+	if !b.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloading %s from %s/%s (ref: %s)...\n",
+			b.opts.Subdir, workspace, repo, b.opts.Branch)
+	}
+
+	if strings.Trim(b.opts.Subdir, "/") != "" {
+		item, err := b.getMeta(workspace, repo, b.opts.Subdir)
+		if err != nil {
+			return err
+		}
+		if item.Type == "commit_file" {
+			return b.downloadSingleFile(workspace, repo, item)
+		}
+	}
+
+	items, err := b.listDirectory(workspace, repo, b.opts.Subdir)
+	if err != nil {
+		return err
+	}
+
+	if err := b.opts.Sink.MkdirAll(b.opts.OutputDir); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, item := range items {
+		if path.Base(item.Path) == upstreamIgnoreFile && item.Type == "commit_file" {
+			fileURL := b.srcURL(workspace, repo, item.Path)
+			err := applyUpstreamIgnore(&b.opts, func(ctx context.Context) (io.ReadCloser, error) {
+				return b.openFile(ctx, fileURL)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	tasks, err := b.directoryTasks(workspace, repo, items, b.opts.OutputDir)
+	if err != nil {
+		return err
+	}
+
+	return downloadFiles(tasks, b.opts.Concurrency, b.opts.Quiet)
+}
+
+// getMeta returns the type and attributes of a single path, which tells
+// files and directories apart.
+func (b *bitbucketAPIDownloader) getMeta(workspace, repo, path string) (BitbucketSrcItem, error) {
+	var item BitbucketSrcItem
+
+	req, err := util.NewBitbucketRequest("GET", b.srcURL(workspace, repo, path)+"?format=meta", b.opts.Token)
+	if err != nil {
+		return item, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return item, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Bitbucket API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return item, errors.ParseBitbucketAPIError(resp.StatusCode, bodyStr)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return item, fmt.Errorf("failed to parse API response: %w", err)
+	}
+	return item, nil
+}
+
+func (b *bitbucketAPIDownloader) downloadSingleFile(workspace, repo string, item BitbucketSrcItem) error {
+	targetPath, err := singleFilePath(b.opts.Sink, b.opts.OutputDir, item.Path)
+	if err != nil {
+		return err
+	}
+	if err := b.downloadFile(context.Background(), b.srcURL(workspace, repo, item.Path), targetPath, item.isExecutable()); err != nil {
+		return err
+	}
+
+	if !b.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloaded %s to %s\n", item.Path, targetPath)
+	}
+	return nil
+}
+
+func (i BitbucketSrcItem) isExecutable() bool {
+	return slices.Contains(i.Attributes, "executable")
+}
+
+func (i BitbucketSrcItem) isLink() bool {
+	return slices.Contains(i.Attributes, "link")
+}
+
+func parseBitbucketURL(repoURL string) (workspace string, repo string, err error) {
+	pattern := regexp.MustCompile(`bitbucket\.org[/:]([^/]+)/([^/]+?)(?:\.git)?/?$`)
+
+	matches := pattern.FindStringSubmatch(repoURL)
+	if matches != nil && len(matches) >= 3 {
+		return matches[1], matches[2], nil
+	}
+
+	return "", "", fmt.Errorf("URL does not match Bitbucket repository pattern: %s", repoURL)
+}
+
+func (b *bitbucketAPIDownloader) apiBaseURL() string {
+	if b.opts.APIURL != "" {
+		return strings.TrimSuffix(b.opts.APIURL, "/")
+	}
+	return BitbucketAPIBaseURL
+}
+
+// resolveCommit returns the full hash of the commit ref points to. The
+// commit endpoint accepts branches, tags and abbreviated hashes.
+func (b *bitbucketAPIDownloader) resolveCommit(workspace, repo, ref string) (string, error) {
+	apiURL := fmt.Sprintf("%s/repositories/%s/%s/commit/%s",
+		b.apiBaseURL(), url.PathEscape(workspace), url.PathEscape(repo), url.PathEscape(ref))
+
+	req, err := util.NewBitbucketRequest("GET", apiURL, b.opts.Token)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Bitbucket API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", refNotFoundError(ref)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return "", errors.ParseBitbucketAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var commit struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&commit); err != nil {
+		return "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return commit.Hash, nil
+}
+
+// bitbucketRefs resolves refs of one workspace/repo for resolveBranch.
+type bitbucketRefs struct {
+	d               *bitbucketAPIDownloader
+	workspace, repo string
+}
+
+func (r bitbucketRefs) defaultBranch() (string, error) {
+	return r.d.getDefaultBranch(r.workspace, r.repo)
+}
+
+func (r bitbucketRefs) resolveCommit(ref string) (string, error) {
+	return r.d.refCommit(r.workspace, r.repo, ref)
+}
+
+// refCommit returns the hash of the commit ref points to. Names with a
+// slash are looked up in the ref listing, the commit endpoint would take
+// the part after the slash for a path.
+func (b *bitbucketAPIDownloader) refCommit(workspace, repo, ref string) (string, error) {
+	if fullCommitPattern.MatchString(ref) {
+		return ref, nil
+	}
+	if !strings.Contains(ref, "/") {
+		return b.resolveCommit(workspace, repo, ref)
+	}
+
+	query := url.Values{}
+	query.Set("q", fmt.Sprintf("name = %q", ref))
+	apiURL := fmt.Sprintf("%s/repositories/%s/%s/refs?%s",
+		b.apiBaseURL(), url.PathEscape(workspace), url.PathEscape(repo), query.Encode())
+
+	req, err := util.NewBitbucketRequest("GET", apiURL, b.opts.Token)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Bitbucket API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return "", errors.ParseBitbucketAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var refs struct {
+		Values []struct {
+			Name   string `json:"name"`
+			Target struct {
+				Hash string `json:"hash"`
+			} `json:"target"`
+		} `json:"values"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&refs); err != nil {
+		return "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	for _, value := range refs.Values {
+		if value.Name == ref {
+			return value.Target.Hash, nil
+		}
+	}
+	return "", refNotFoundError(ref)
+}
+
+// getDefaultBranch reads the main branch from the repository metadata.
+func (b *bitbucketAPIDownloader) getDefaultBranch(workspace, repo string) (string, error) {
+	apiURL := fmt.Sprintf("%s/repositories/%s/%s",
+		b.apiBaseURL(), url.PathEscape(workspace), url.PathEscape(repo))
+
+	req, err := util.NewBitbucketRequest("GET", apiURL, b.opts.Token)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Bitbucket API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return "", errors.ParseBitbucketAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var meta struct {
+		MainBranch struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return meta.MainBranch.Name, nil
+}
+
+func (b *bitbucketAPIDownloader) srcURL(workspace, repo, path string) string {
+	return fmt.Sprintf("%s/repositories/%s/%s/src/%s/%s",
+		b.apiBaseURL(), url.PathEscape(workspace), url.PathEscape(repo),
+		url.PathEscape(b.commit), escapePath(path))
+}
+
+// collectFiles walks path, creating the directory structure below outputDir
+// and returning the files still to be fetched.
+func (b *bitbucketAPIDownloader) collectFiles(workspace, repo, path, outputDir string) ([]fileTask, error) {
+	items, err := b.listDirectory(workspace, repo, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.directoryTasks(workspace, repo, items, outputDir)
+}
+
+// directoryTasks creates the directory structure for a listing below
+// outputDir and returns the files still to be fetched.
+func (b *bitbucketAPIDownloader) directoryTasks(workspace, repo string, items []BitbucketSrcItem, outputDir string) ([]fileTask, error) {
+	var tasks []fileTask
+	for _, item := range items {
+		targetPath, err := safepath.Join(outputDir, path.Base(item.Path))
+		if err != nil {
+			return nil, err
+		}
+		relPath := relativePath(item.Path, b.opts.Subdir)
+
+		if item.Type == "commit_directory" {
+			if b.opts.Filter.Prune(relPath) {
+				continue
+			}
+			if b.opts.Filter.Match(relPath, true) {
+				if err := b.opts.Sink.MkdirAll(targetPath); err != nil {
+					return nil, fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+				}
+			}
+
+			subTasks, err := b.collectFiles(workspace, repo, item.Path, targetPath)
+			if err != nil {
+				return nil, err
+			}
+			tasks = append(tasks, subTasks...)
+		} else if item.Type == "commit_file" && item.isLink() {
+			if b.opts.Symlinks == output.SymlinksSkip || !b.opts.Filter.Match(relPath, false) {
+				continue
+			}
+			fileURL := b.srcURL(workspace, repo, item.Path)
+			tasks = append(tasks, fileTask{
+				repoPath: item.Path,
+				fetch: func(ctx context.Context) error {
+					return downloadSymlink(ctx, b.opts.Sink, relPath, targetPath, func(ctx context.Context) (io.ReadCloser, error) {
+						return b.openFile(ctx, fileURL)
+					})
+				},
+			})
+		} else if item.Type == "commit_file" && b.opts.Filter.Match(relPath, false) {
+			fileURL := b.srcURL(workspace, repo, item.Path)
+			executable := item.isExecutable()
+			tasks = append(tasks, fileTask{
+				repoPath: item.Path,
+				fetch: func(ctx context.Context) error {
+					return b.downloadFile(ctx, fileURL, targetPath, executable)
+				},
+			})
+		}
+	}
+
+	return tasks, nil
+}
+
+// listDirectory collects a directory listing, following the "next" links of
+// Bitbucket's paginated responses.
+func (b *bitbucketAPIDownloader) listDirectory(workspace, repo, path string) ([]BitbucketSrcItem, error) {
+	var items []BitbucketSrcItem
+
+	apiURL := fmt.Sprintf("%s/?pagelen=%d", strings.TrimSuffix(b.srcURL(workspace, repo, path), "/"), bitbucketPageLen)
+	for apiURL != "" {
+		req, err := util.NewBitbucketRequest("GET", apiURL, b.opts.Token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return nil, &errors.AppError{
+				Err:     errors.ErrNetworkFailure,
+				Message: "Failed to connect to Bitbucket API",
+				Hint:    "Check your internet connection and try again",
+			}
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			bodyStr := strings.TrimSpace(string(body))
+			return nil, errors.ParseBitbucketAPIError(resp.StatusCode, bodyStr)
+		}
+
+		var page bitbucketSrcPage
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse API response: %w", err)
+		}
+
+		items = append(items, page.Values...)
+		apiURL = page.Next
+	}
+
+	return items, nil
+}
+
+// openFile requests the raw content of a file.
+func (b *bitbucketAPIDownloader) openFile(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := util.NewBitbucketRequest("GET", url, b.opts.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to download file",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return nil, errors.ParseBitbucketAPIError(resp.StatusCode, bodyStr)
+	}
+
+	return resp.Body, nil
+}
+
+// downloadFile saves the file to outputPath in the output sink.
+func (b *bitbucketAPIDownloader) downloadFile(ctx context.Context, url, outputPath string, executable bool) error {
+	body, err := b.openFile(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return b.opts.Sink.WriteFile(outputPath, body, executable)
+}
+
+// bitbucketArchiveSource requests the repository tarball from the web
+// download endpoint, the 2.0 API has no archive resource.
+var bitbucketArchiveSource = archiveSource{
+	provider: "Bitbucket",
+	request: func(opts model.DownloadOptions) (*http.Request, error) {
+		workspace, repo, err := parseBitbucketURL(opts.RepoURL)
+		if err != nil {
+			return nil, &errors.AppError{
+				Err:     errors.ErrInvalidURL,
+				Message: "Invalid Bitbucket URL format",
+				Hint:    "URL should be in the format: https://bitbucket.org/workspace/repo",
+			}
+		}
+
+		ref := opts.Branch
+		if ref == "" {
+			ref = "HEAD"
+		}
+
+		archiveURL := fmt.Sprintf("%s/%s/%s/get/%s.tar.gz",
+			BitbucketWebBaseURL, url.PathEscape(workspace), url.PathEscape(repo), url.PathEscape(ref))
+
+		return util.NewBitbucketRequest("GET", archiveURL, opts.Token)
+	},
+}
@@ -2,15 +2,59 @@ package downloader
 
 import (
 	"fmt"
+
 	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
 )
 
 func GetDownloader(opts model.DownloadOptions) (Downloader, error) {
+	// The sink is closed by the caller once the download succeeded.
+	if opts.Sink == nil {
+		return nil, fmt.Errorf("no output sink configured")
+	}
+
+	dl, err := newDownloader(opts)
+	if err != nil {
+		return nil, err
+	}
+	// Sparse checkout finds submodules in the trees it fetches anyway.
+	if opts.RecurseSubmodules && opts.Method != model.MethodTypeSparse {
+		dl = &submoduleDownloader{Downloader: dl, opts: opts}
+	}
+	return dl, nil
+}
+
+func newDownloader(opts model.DownloadOptions) (Downloader, error) {
 	switch opts.Method {
 	case model.MethodTypeAPI:
 		switch opts.Provider {
 		case model.ProviderTypeGitHub:
 			return NewGitHubAPIDownloader(opts), nil
+		case model.ProviderTypeGitLab:
+			return NewGitLabAPIDownloader(opts), nil
+		case model.ProviderTypeGitea:
+			return NewGiteaAPIDownloader(opts), nil
+		case model.ProviderTypeBitbucket:
+			return NewBitbucketAPIDownloader(opts), nil
+		case model.ProviderTypeAzureDevOps:
+			return NewAzureDevOpsAPIDownloader(opts), nil
+		}
+	case model.MethodTypeArchive:
+		switch opts.Provider {
+		case model.ProviderTypeGitHub:
+			return NewArchiveDownloader(opts, gitHubArchiveSource), nil
+		case model.ProviderTypeGitLab:
+			return NewArchiveDownloader(opts, gitLabArchiveSource), nil
+		case model.ProviderTypeGitea:
+			return NewArchiveDownloader(opts, giteaArchiveSource), nil
+		case model.ProviderTypeBitbucket:
+			return NewArchiveDownloader(opts, bitbucketArchiveSource), nil
+		case model.ProviderTypeAzureDevOps:
+			return nil, &errors.AppError{
+				Err:     errors.ErrUnsupportedMethod,
+				Message: "The archive method does not support Azure DevOps",
+				Hint:    "Use --method api or --method sparse",
+			}
 		}
 	case model.MethodTypeSparse:
 		return NewSparseCheckoutDownloader(opts), nil
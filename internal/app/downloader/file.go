@@ -0,0 +1,31 @@
+package downloader
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+// singleFilePath returns where a download of the single file repoPath is
+// written: into outputDir when that is an existing directory of sink or ends
+// with a path separator, otherwise outputDir is the path of the file itself.
+func singleFilePath(sink output.Sink, outputDir, repoPath string) (string, error) {
+	name := path.Base(strings.Trim(repoPath, "/"))
+	if strings.HasSuffix(outputDir, "/") || strings.HasSuffix(outputDir, string(filepath.Separator)) || sink.IsDir(outputDir) {
+		return safepath.Join(outputDir, name)
+	}
+	return outputDir, nil
+}
+
+func pathNotFoundError(repoPath string) error {
+	return &errors.AppError{
+		Err:     errors.ErrPathNotFound,
+		Message: fmt.Sprintf("Path '%s' not found in the repository", repoPath),
+		Hint:    "Check that the file or folder path exists in the specified branch",
+	}
+}
@@ -0,0 +1,577 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+	"github.com/dagimg-dot/gitsnip/internal/util"
+)
+
+const (
+	GiteaAPIPath = "/api/v1"
+)
+
+type GiteaContentItem struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	SHA         string `json:"sha"`
+	DownloadURL string `json:"download_url"`
+	URL         string `json:"url"`
+	// Target is the link target of a symlink.
+	Target string `json:"target"`
+}
+
+func NewGiteaAPIDownloader(opts model.DownloadOptions) Downloader {
+	return &giteaAPIDownloader{
+		opts:   opts,
+		client: util.NewHTTPClient(opts.Token),
+	}
+}
+
+type giteaAPIDownloader struct {
+	opts   model.DownloadOptions
+	client *http.Client
+	// gitmodulesContent is the commit's .gitmodules, read for
+	// RecurseSubmodules.
+	gitmodulesContent []byte
+}
+
+func (g *giteaAPIDownloader) Download() error {
+	baseURL, owner, repo, err := parseGiteaURL(g.opts.RepoURL)
+	if err != nil {
+		return &errors.AppError{
+			Err:     errors.ErrInvalidURL,
+			Message: "Invalid Gitea URL format",
+			Hint:    "URL should be in the format: https://codeberg.org/owner/repo",
+		}
+	}
+
+	if g.opts.APIURL != "" {
+		baseURL = strings.TrimSuffix(g.opts.APIURL, "/")
+	}
+
+	if err := resolveBranch(&g.opts, giteaRefs{g, baseURL, owner, repo}); err != nil {
+		return err
+	}
+
+	if g.opts.RecurseSubmodules {
+		rawURL := fmt.Sprintf("%s/repos/%s/%s/raw/%s?ref=%s",
+			baseURL, url.PathEscape(owner), url.PathEscape(repo), gitmodulesFile, url.QueryEscape(g.opts.Branch))
+		g.gitmodulesContent, err = readGitmodules(func(ctx context.Context) (io.ReadCloser, error) {
+			return g.openFile(ctx, rawURL)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return forEachTarget(&g.opts, func() error {
+		return g.downloadPath(baseURL, owner, repo)
+	})
+}
+
+func (g *giteaAPIDownloader) gitmodules() []byte {
+	return g.gitmodulesContent
+}
+
+// downloadPath downloads Subdir, a directory or a single file, to OutputDir.
+func (g *giteaAPIDownloader) downloadPath(baseURL, owner, repo string) error {
+	if !g.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloading %s from %s/%s (ref: %s)...\n",
+			g.opts.Subdir, owner, repo, g.opts.Branch)
+	}
+
+	items, err := g.getContents(baseURL, owner, repo, g.opts.Subdir)
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 1 && items[0].Type == "file" && items[0].Path == strings.Trim(g.opts.Subdir, "/") {
+		return g.downloadSingleFile(baseURL, owner, repo, items[0])
+	}
+
+	if err := g.opts.Sink.MkdirAll(g.opts.OutputDir); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, item := range items {
+		if item.Name == upstreamIgnoreFile && item.Type == "file" {
+			downloadURL := item.DownloadURL
+			err := applyUpstreamIgnore(&g.opts, func(ctx context.Context) (io.ReadCloser, error) {
+				return g.openFile(ctx, downloadURL)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Listings report no modes, they come from the git tree of the folder.
+	treeSHA, err := g.treeSHA(baseURL, owner, repo, g.opts.Subdir)
+	if err != nil {
+		return err
+	}
+	modes, err := g.getTreeModes(baseURL, owner, repo, treeSHA, true)
+	if err != nil {
+		return err
+	}
+
+	tasks, err := g.directoryTasks(baseURL, owner, repo, items, g.opts.OutputDir, modes)
+	if err != nil {
+		return err
+	}
+
+	return downloadFiles(tasks, g.opts.Concurrency, g.opts.Quiet)
+}
+
+// downloadSingleFile fetches a file. The Contents API does not report file
+// modes, so the executable bit is read from the parent's git tree.
+func (g *giteaAPIDownloader) downloadSingleFile(baseURL, owner, repo string, item GiteaContentItem) error {
+	executable, err := g.isExecutable(baseURL, owner, repo, item.Path)
+	if err != nil {
+		return err
+	}
+
+	targetPath, err := singleFilePath(g.opts.Sink, g.opts.OutputDir, item.Path)
+	if err != nil {
+		return err
+	}
+	if err := g.downloadFile(context.Background(), item.DownloadURL, targetPath, executable); err != nil {
+		return err
+	}
+
+	if !g.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloaded %s to %s\n", item.Path, targetPath)
+	}
+	return nil
+}
+
+// isExecutable reads the mode of filePath from the git tree of its parent
+// directory.
+func (g *giteaAPIDownloader) isExecutable(baseURL, owner, repo, filePath string) (bool, error) {
+	treeSHA, err := g.treeSHA(baseURL, owner, repo, path.Dir(filePath))
+	if err != nil {
+		return false, err
+	}
+	modes, err := g.getTreeModes(baseURL, owner, repo, treeSHA, false)
+	if err != nil {
+		return false, err
+	}
+	return modes[path.Base(filePath)] == gitModeExecutable, nil
+}
+
+// treeSHA returns the SHA of the git tree of dir, found in the listing of
+// its parent. The root tree is addressed by the ref.
+func (g *giteaAPIDownloader) treeSHA(baseURL, owner, repo, dir string) (string, error) {
+	dir = strings.Trim(dir, "/")
+	if dir == "" || dir == "." {
+		if g.opts.Branch == "" {
+			return "HEAD", nil
+		}
+		return g.opts.Branch, nil
+	}
+
+	parent := path.Dir(dir)
+	if parent == "." {
+		parent = ""
+	}
+	items, err := g.getContents(baseURL, owner, repo, parent)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range items {
+		if item.Path == dir {
+			return item.SHA, nil
+		}
+	}
+	return "", pathNotFoundError(dir)
+}
+
+// getTreeModes returns the git mode of the entries of the tree treeSHA,
+// keyed by their path relative to it. Recursive listings come in pages.
+func (g *giteaAPIDownloader) getTreeModes(baseURL, owner, repo, treeSHA string, recursive bool) (map[string]string, error) {
+	modes := make(map[string]string)
+	for page := 1; ; page++ {
+		query := url.Values{}
+		if recursive {
+			query.Set("recursive", "true")
+			query.Set("page", fmt.Sprint(page))
+		}
+		apiURL := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s?%s",
+			baseURL, url.PathEscape(owner), url.PathEscape(repo), url.PathEscape(treeSHA), query.Encode())
+
+		req, err := util.NewGiteaRequest("GET", apiURL, g.opts.Token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := g.client.Do(req)
+		if err != nil {
+			return nil, &errors.AppError{
+				Err:     errors.ErrNetworkFailure,
+				Message: "Failed to connect to Gitea API",
+				Hint:    "Check your internet connection and try again",
+			}
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			bodyStr := strings.TrimSpace(string(body))
+			return nil, errors.ParseGiteaAPIError(resp.StatusCode, bodyStr)
+		}
+
+		var tree struct {
+			Tree      []GitHubTreeEntry `json:"tree"`
+			Truncated bool              `json:"truncated"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&tree)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse API response: %w", err)
+		}
+
+		added := false
+		for _, entry := range tree.Tree {
+			if _, seen := modes[entry.Path]; !seen {
+				modes[entry.Path] = entry.Mode
+				added = true
+			}
+		}
+		// A page without new entries ends the listing even if the server
+		// still reports it as truncated.
+		if !recursive || !tree.Truncated || !added {
+			return modes, nil
+		}
+	}
+}
+
+// parseGiteaURL splits a Gitea, Forgejo or Codeberg repository URL into the
+// API base URL of the instance, the owner and the repository name. Instances
+// served from a sub path (https://host/gitea/owner/repo) keep that path.
+func parseGiteaURL(repoURL string) (baseURL string, owner string, repo string, err error) {
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`^(https?://[^/]+(?:/.+)?)/([^/]+)/([^/]+?)(?:\.git)?/?$`),
+		regexp.MustCompile(`^git@([^:]+):([^/]+)/([^/]+?)(?:\.git)?$`),
+		regexp.MustCompile(`^([^/:]+\.[^/:]+(?:/.+)?)/([^/]+)/([^/]+?)(?:\.git)?/?$`),
+	}
+
+	for i, pattern := range patterns {
+		matches := pattern.FindStringSubmatch(repoURL)
+		if matches != nil && len(matches) >= 4 {
+			baseURL = matches[1]
+			if i > 0 {
+				baseURL = "https://" + baseURL
+			}
+			return baseURL + GiteaAPIPath, matches[2], matches[3], nil
+		}
+	}
+
+	return "", "", "", fmt.Errorf("URL does not match Gitea repository pattern: %s", repoURL)
+}
+
+// resolveCommit returns the full SHA of the commit ref points to. The git
+// commits endpoint accepts branches, tags and abbreviated SHAs.
+func (g *giteaAPIDownloader) resolveCommit(baseURL, owner, repo, ref string) (string, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/commits/%s",
+		baseURL, url.PathEscape(owner), url.PathEscape(repo), url.PathEscape(ref))
+
+	req, err := util.NewGiteaRequest("GET", apiURL, g.opts.Token)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Gitea API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity {
+		return "", refNotFoundError(ref)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return "", errors.ParseGiteaAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&commit); err != nil {
+		return "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return commit.SHA, nil
+}
+
+// giteaRefs resolves refs of one owner/repo for resolveBranch.
+type giteaRefs struct {
+	d                    *giteaAPIDownloader
+	baseURL, owner, repo string
+}
+
+func (r giteaRefs) defaultBranch() (string, error) {
+	return r.d.getDefaultBranch(r.baseURL, r.owner, r.repo)
+}
+
+func (r giteaRefs) resolveCommit(ref string) (string, error) {
+	return r.d.resolveCommit(r.baseURL, r.owner, r.repo, ref)
+}
+
+// getDefaultBranch reads the default branch from the repository metadata.
+func (g *giteaAPIDownloader) getDefaultBranch(baseURL, owner, repo string) (string, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s", baseURL, url.PathEscape(owner), url.PathEscape(repo))
+
+	req, err := util.NewGiteaRequest("GET", apiURL, g.opts.Token)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Gitea API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return "", errors.ParseGiteaAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var meta struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return meta.DefaultBranch, nil
+}
+
+func (g *giteaAPIDownloader) listDirectory(baseURL, owner, repo, path, outputDir string, modes map[string]string) ([]fileTask, error) {
+	items, err := g.getContents(baseURL, owner, repo, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.directoryTasks(baseURL, owner, repo, items, outputDir, modes)
+}
+
+// directoryTasks creates the directory structure for a listing below
+// outputDir and returns the files still to be fetched. modes maps paths
+// relative to Subdir to their git mode.
+func (g *giteaAPIDownloader) directoryTasks(baseURL, owner, repo string, items []GiteaContentItem, outputDir string, modes map[string]string) ([]fileTask, error) {
+	var tasks []fileTask
+	for _, item := range items {
+		targetPath, err := safepath.Join(outputDir, item.Name)
+		if err != nil {
+			return nil, err
+		}
+		relPath := relativePath(item.Path, g.opts.Subdir)
+
+		if item.Type == "dir" {
+			if g.opts.Filter.Prune(relPath) {
+				continue
+			}
+			if g.opts.Filter.Match(relPath, true) {
+				if err := g.opts.Sink.MkdirAll(targetPath); err != nil {
+					return nil, fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+				}
+			}
+
+			subTasks, err := g.listDirectory(baseURL, owner, repo, item.Path, targetPath, modes)
+			if err != nil {
+				return nil, err
+			}
+			tasks = append(tasks, subTasks...)
+		} else if item.Type == "file" && g.opts.Filter.Match(relPath, false) {
+			downloadURL := item.DownloadURL
+			executable := modes[relPath] == gitModeExecutable
+			tasks = append(tasks, fileTask{
+				repoPath: item.Path,
+				fetch: func(ctx context.Context) error {
+					return g.downloadFile(ctx, downloadURL, targetPath, executable)
+				},
+			})
+		} else if item.Type == "symlink" && g.opts.Symlinks != output.SymlinksSkip && g.opts.Filter.Match(relPath, false) {
+			// Listings carry the target, older servers leave it to the
+			// content of the link.
+			if item.Target != "" {
+				if err := writeSymlink(g.opts.Sink, relPath, targetPath, strings.NewReader(item.Target)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			downloadURL := item.DownloadURL
+			tasks = append(tasks, fileTask{
+				repoPath: item.Path,
+				fetch: func(ctx context.Context) error {
+					return downloadSymlink(ctx, g.opts.Sink, relPath, targetPath, func(ctx context.Context) (io.ReadCloser, error) {
+						return g.openFile(ctx, downloadURL)
+					})
+				},
+			})
+		}
+	}
+
+	return tasks, nil
+}
+
+func (g *giteaAPIDownloader) getContents(baseURL, owner, repo, path string) ([]GiteaContentItem, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/contents/%s",
+		baseURL, url.PathEscape(owner), url.PathEscape(repo), escapePath(path))
+
+	if g.opts.Branch != "" {
+		apiURL = fmt.Sprintf("%s?ref=%s", apiURL, url.QueryEscape(g.opts.Branch))
+	}
+
+	req, err := util.NewGiteaRequest("GET", apiURL, g.opts.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to Gitea API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return nil, errors.ParseGiteaAPIError(resp.StatusCode, bodyStr)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response: %w", err)
+	}
+
+	// The contents endpoint returns an object instead of a list for files.
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var item GiteaContentItem
+		if err := json.Unmarshal(trimmed, &item); err != nil {
+			return nil, fmt.Errorf("failed to parse API response: %w", err)
+		}
+		return []GiteaContentItem{item}, nil
+	}
+
+	var items []GiteaContentItem
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return items, nil
+}
+
+// openFile requests the raw content of a file.
+func (g *giteaAPIDownloader) openFile(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := util.NewGiteaRequest("GET", url, g.opts.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Del("Accept")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to download file",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return nil, errors.ParseGiteaAPIError(resp.StatusCode, bodyStr)
+	}
+
+	return resp.Body, nil
+}
+
+// downloadFile saves the file to outputPath in the output sink.
+func (g *giteaAPIDownloader) downloadFile(ctx context.Context, url, outputPath string, executable bool) error {
+	body, err := g.openFile(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return g.opts.Sink.WriteFile(outputPath, body, executable)
+}
+
+// escapePath escapes every segment of a slash separated repository path
+// while keeping the separators intact.
+func escapePath(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// giteaArchiveSource requests the repository tarball at the configured ref.
+var giteaArchiveSource = archiveSource{
+	provider: "Gitea",
+	request: func(opts model.DownloadOptions) (*http.Request, error) {
+		baseURL, owner, repo, err := parseGiteaURL(opts.RepoURL)
+		if err != nil {
+			return nil, &errors.AppError{
+				Err:     errors.ErrInvalidURL,
+				Message: "Invalid Gitea URL format",
+				Hint:    "URL should be in the format: https://codeberg.org/owner/repo",
+			}
+		}
+
+		if opts.APIURL != "" {
+			baseURL = strings.TrimSuffix(opts.APIURL, "/")
+		}
+
+		ref := opts.Branch
+		if ref == "" {
+			ref = "HEAD"
+		}
+
+		apiURL := fmt.Sprintf("%s/repos/%s/%s/archive/%s.tar.gz",
+			baseURL, url.PathEscape(owner), url.PathEscape(repo), escapePath(ref))
+
+		req, err := util.NewGiteaRequest("GET", apiURL, opts.Token)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Del("Accept")
+		return req, nil
+	},
+}
@@ -1,16 +1,21 @@
 package downloader
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"path/filepath"
+	"os"
+	"path"
 	"regexp"
 	"strings"
 
 	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
 	"github.com/dagimg-dot/gitsnip/internal/errors"
 	"github.com/dagimg-dot/gitsnip/internal/util"
 )
@@ -23,6 +28,7 @@ type GitHubContentItem struct {
 	Name        string `json:"name"`
 	Path        string `json:"path"`
 	Type        string `json:"type"`
+	SHA         string `json:"sha"`
 	DownloadURL string `json:"download_url"`
 	URL         string `json:"url"`
 }
@@ -35,81 +41,271 @@ func NewGitHubAPIDownloader(opts model.DownloadOptions) Downloader {
 }
 
 type gitHubAPIDownloader struct {
-	opts   model.DownloadOptions
-	client *http.Client
+	opts    model.DownloadOptions
+	client  *http.Client
+	baseURL string
+	// rawBaseURL serves file content at commit, outside the API rate limit.
+	rawBaseURL string
+	commit     string
+	// gitmodulesContent is the commit's .gitmodules, read for
+	// RecurseSubmodules.
+	gitmodulesContent []byte
 }
 
 func (g *gitHubAPIDownloader) Download() error {
-	owner, repo, err := parseGitHubURL(g.opts.RepoURL)
+	host, owner, repo, err := parseGitHubURL(g.opts.RepoURL)
 	if err != nil {
 		return &errors.AppError{
 			Err:     errors.ErrInvalidURL,
 			Message: "Invalid GitHub URL format",
-			Hint:    "URL should be in the format: https://github.com/owner/repo",
+			Hint:    "URL should be in the format: https://github.com/owner/repo (use --provider for other hosts)",
 		}
 	}
 
-	if err := util.EnsureDir(g.opts.OutputDir); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	g.baseURL = g.opts.APIURL
+	if g.baseURL == "" {
+		g.baseURL = gitHubAPIBaseURL(host)
 	}
+	g.baseURL = strings.TrimSuffix(g.baseURL, "/")
 
-	if !g.opts.Quiet {
-		fmt.Printf("Downloading directory %s from %s/%s (branch: %s)...\n",
-			g.opts.Subdir, owner, repo, g.opts.Branch)
+	if err := resolveBranch(&g.opts, gitHubRefs{g, owner, repo}); err != nil {
+		return err
+	}
+	g.commit = g.opts.Branch
+	if !fullCommitPattern.MatchString(g.commit) {
+		if g.commit, err = g.resolveCommit(owner, repo, g.opts.Branch); err != nil {
+			return err
+		}
 	}
+	g.rawBaseURL = gitHubRawBaseURL(g.baseURL, owner, repo)
 
-	return g.downloadDirectory(owner, repo, g.opts.Subdir, g.opts.OutputDir)
+	if g.opts.RecurseSubmodules {
+		g.gitmodulesContent, err = readGitmodules(func(ctx context.Context) (io.ReadCloser, error) {
+			return g.openFile(ctx, g.rawURL(gitmodulesFile))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return forEachTarget(&g.opts, func() error {
+		if !g.opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Downloading %s from %s/%s (ref: %s)...\n",
+				g.opts.Subdir, owner, repo, g.opts.Branch)
+		}
+		return g.downloadTree(owner, repo)
+	})
 }
 
-func parseGitHubURL(repoURL string) (owner string, repo string, err error) {
+func (g *gitHubAPIDownloader) gitmodules() []byte {
+	return g.gitmodulesContent
+}
+
+// parseGitHubURL extracts the host, owner and repository name from a
+// github.com or GitHub Enterprise Server URL. The host keeps its scheme when
+// one was given so plain http instances resolve to an http API.
+func parseGitHubURL(repoURL string) (host string, owner string, repo string, err error) {
 	patterns := []*regexp.Regexp{
-		regexp.MustCompile(`github\.com[/:]([^/]+)/([^/]+?)(?:\.git)?$`),
-		regexp.MustCompile(`github\.com[/:]([^/]+)/([^/]+?)(?:\.git)?$`),
+		regexp.MustCompile(`^(https?://)(?:[^@/]+@)?([^/]+)/([^/]+)/([^/]+?)(?:\.git)?/?$`),
+		regexp.MustCompile(`^()(?:[^@/]+@)?([^/:]+):([^/]+)/([^/]+?)(?:\.git)?$`),
+		regexp.MustCompile(`^()([^/:]+(?::\d+)?)/([^/]+)/([^/]+?)(?:\.git)?/?$`),
 	}
 
 	for _, pattern := range patterns {
 		matches := pattern.FindStringSubmatch(repoURL)
-		if matches != nil && len(matches) >= 3 {
-			return matches[1], matches[2], nil
+		if matches != nil && len(matches) >= 5 {
+			return matches[1] + matches[2], matches[3], matches[4], nil
 		}
 	}
 
-	return "", "", fmt.Errorf("URL does not match GitHub repository pattern: %s", repoURL)
+	return "", "", "", fmt.Errorf("URL does not match GitHub repository pattern: %s", repoURL)
+}
+
+// gitHubAPIBaseURL maps a repository host to its REST API root: github.com
+// is served from api.github.com and Enterprise Server instances from /api/v3.
+func gitHubAPIBaseURL(host string) string {
+	scheme := "https://"
+	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
+		scheme, host, _ = strings.Cut(host, "//")
+		scheme += "//"
+	}
+
+	if strings.EqualFold(host, "github.com") || strings.EqualFold(host, "www.github.com") {
+		return GitHubAPIBaseURL
+	}
+
+	return scheme + host + "/api/v3"
+}
+
+// gitHubRawBaseURL returns where raw file content of owner/repo is served:
+// raw.githubusercontent.com for github.com and /raw/ on the web host of an
+// Enterprise Server.
+func gitHubRawBaseURL(apiBaseURL, owner, repo string) string {
+	if apiBaseURL == GitHubAPIBaseURL {
+		return fmt.Sprintf("https://raw.githubusercontent.com/%s/%s", owner, repo)
+	}
+	return fmt.Sprintf("%s/%s/%s/raw", strings.TrimSuffix(apiBaseURL, "/api/v3"), owner, repo)
+}
+
+// rawURL returns the URL of the content of repoPath at the resolved commit.
+func (g *gitHubAPIDownloader) rawURL(repoPath string) string {
+	return fmt.Sprintf("%s/%s/%s", g.rawBaseURL, g.commit, escapePath(repoPath))
+}
+
+// gitHubRefs resolves refs of one owner/repo for resolveBranch.
+type gitHubRefs struct {
+	d           *gitHubAPIDownloader
+	owner, repo string
+}
+
+func (r gitHubRefs) defaultBranch() (string, error) {
+	return r.d.getDefaultBranch(r.owner, r.repo)
+}
+
+func (r gitHubRefs) resolveCommit(ref string) (string, error) {
+	return r.d.resolveCommit(r.owner, r.repo, ref)
 }
 
-func (g *gitHubAPIDownloader) downloadDirectory(owner, repo, path, outputDir string) error {
-	items, err := g.getContents(owner, repo, path)
+// getDefaultBranch reads the default branch from the repository metadata.
+func (g *gitHubAPIDownloader) getDefaultBranch(owner, repo string) (string, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s", g.baseURL, owner, repo)
+
+	req, err := util.NewGitHubRequest("GET", apiURL, g.opts.Token)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to GitHub API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return "", errors.ParseGitHubAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var meta struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return meta.DefaultBranch, nil
+}
+
+// resolveCommit returns the full SHA of the commit ref points to. The commits
+// endpoint accepts branches, tags, other refs and abbreviated SHAs.
+func (g *gitHubAPIDownloader) resolveCommit(owner, repo, ref string) (string, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/commits/%s", g.baseURL, owner, repo, escapePath(ref))
+
+	req, err := util.NewGitHubRequest("GET", apiURL, g.opts.Token)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.sha")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to GitHub API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	bodyStr := strings.TrimSpace(string(body))
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return bodyStr, nil
+	case http.StatusNotFound, http.StatusUnprocessableEntity:
+		return "", refNotFoundError(ref)
+	default:
+		return "", errors.ParseGitHubAPIError(resp.StatusCode, bodyStr)
+	}
+}
+
+func (g *gitHubAPIDownloader) downloadDirectory(owner, repo, dirPath, treeSHA, outputDir string) error {
+	tasks, err := g.listDirectory(owner, repo, dirPath, treeSHA, outputDir)
 	if err != nil {
 		return err
 	}
 
-	for _, item := range items {
-		targetPath := filepath.Join(outputDir, item.Name)
+	return downloadFiles(tasks, g.opts.Concurrency, g.opts.Quiet)
+}
+
+// listDirectory walks the git tree treeSHA of dirPath one directory per
+// call, creating the directory structure below outputDir and returning the
+// files still to be fetched.
+func (g *gitHubAPIDownloader) listDirectory(owner, repo, dirPath, treeSHA, outputDir string) ([]fileTask, error) {
+	tree, err := g.getTree(owner, repo, treeSHA, false)
+	if err != nil {
+		return nil, err
+	}
 
-		if item.Type == "dir" {
-			if err := util.EnsureDir(targetPath); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
-			}
+	var tasks []fileTask
+	for _, entry := range tree.Tree {
+		targetPath, err := safepath.Join(outputDir, entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		repoPath := path.Join(strings.Trim(dirPath, "/"), entry.Path)
+		relPath := relativePath(repoPath, g.opts.Subdir)
 
-			if err := g.downloadDirectory(owner, repo, item.Path, targetPath); err != nil {
-				return err
+		switch {
+		case entry.Type == "tree":
+			if g.opts.Filter.Prune(relPath) {
+				continue
 			}
-		} else if item.Type == "file" {
-			if !g.opts.Quiet {
-				fmt.Printf("Downloading %s\n", item.Path)
+			if g.opts.Filter.Match(relPath, true) {
+				if err := g.opts.Sink.MkdirAll(targetPath); err != nil {
+					return nil, fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+				}
 			}
-			if err := g.downloadFile(item.DownloadURL, targetPath); err != nil {
-				return fmt.Errorf("failed to download file %s: %w", item.Path, err)
+
+			subTasks, err := g.listDirectory(owner, repo, repoPath, entry.SHA, targetPath)
+			if err != nil {
+				return nil, err
 			}
+			tasks = append(tasks, subTasks...)
+		case entry.Type == "blob" && entry.Mode != gitModeSymlink && g.opts.Filter.Match(relPath, false):
+			fileURL := g.rawURL(repoPath)
+			executable := entry.Mode == gitModeExecutable
+			tasks = append(tasks, fileTask{
+				repoPath: repoPath,
+				fetch: func(ctx context.Context) error {
+					return g.downloadFile(ctx, fileURL, targetPath, executable)
+				},
+			})
+		case entry.Type == "blob" && entry.Mode == gitModeSymlink && g.opts.Symlinks != output.SymlinksSkip && g.opts.Filter.Match(relPath, false):
+			blobURL := fmt.Sprintf("%s/repos/%s/%s/git/blobs/%s", g.baseURL, owner, repo, entry.SHA)
+			tasks = append(tasks, fileTask{
+				repoPath: repoPath,
+				fetch: func(ctx context.Context) error {
+					return downloadSymlink(ctx, g.opts.Sink, relPath, targetPath, func(ctx context.Context) (io.ReadCloser, error) {
+						return g.openFile(ctx, blobURL)
+					})
+				},
+			})
 		}
 	}
 
-	return nil
+	return tasks, nil
 }
 
 func (g *gitHubAPIDownloader) getContents(owner, repo, path string) ([]GitHubContentItem, error) {
 	apiURL := fmt.Sprintf("%s/repos/%s/%s/contents/%s",
-		GitHubAPIBaseURL, owner, repo, url.PathEscape(path))
+		g.baseURL, owner, repo, escapePath(path))
 
 	if g.opts.Branch != "" {
 		apiURL = fmt.Sprintf("%s?ref=%s", apiURL, url.QueryEscape(g.opts.Branch))
@@ -136,43 +332,95 @@ func (g *gitHubAPIDownloader) getContents(owner, repo, path string) ([]GitHubCon
 		return nil, errors.ParseGitHubAPIError(resp.StatusCode, bodyStr)
 	}
 
+	// Directories are listed as an array, a file path yields a single object.
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
 	var items []GitHubContentItem
-	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
 		var item GitHubContentItem
-		if errSingle := json.Unmarshal([]byte(err.Error()), &item); errSingle == nil {
-			return []GitHubContentItem{item}, nil
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, fmt.Errorf("failed to parse API response: %w", err)
 		}
+		return []GitHubContentItem{item}, nil
+	}
+	if err := json.Unmarshal(raw, &items); err != nil {
 		return nil, fmt.Errorf("failed to parse API response: %w", err)
 	}
 
 	return items, nil
 }
 
-func (g *gitHubAPIDownloader) downloadFile(url, outputPath string) error {
-	req, err := http.NewRequest("GET", url, nil)
+// openFile requests the raw content of a file from the raw host or the
+// Contents API.
+func (g *gitHubAPIDownloader) openFile(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	// API URLs only return the raw content with this media type.
+	req.Header.Set("Accept", "application/vnd.github.raw")
 	if g.opts.Token != "" {
 		req.Header.Set("Authorization", "token "+g.opts.Token)
 	}
 
 	resp, err := g.client.Do(req)
 	if err != nil {
-		return &errors.AppError{
+		return nil, &errors.AppError{
 			Err:     errors.ErrNetworkFailure,
 			Message: "Failed to download file",
 			Hint:    "Check your internet connection and try again",
 		}
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
 		bodyStr := strings.TrimSpace(string(body))
-		return errors.ParseGitHubAPIError(resp.StatusCode, bodyStr)
+		return nil, errors.ParseGitHubAPIError(resp.StatusCode, bodyStr)
+	}
+
+	return resp.Body, nil
+}
+
+// downloadFile saves the file to outputPath in the output sink.
+func (g *gitHubAPIDownloader) downloadFile(ctx context.Context, url, outputPath string, executable bool) error {
+	body, err := g.openFile(ctx, url)
+	if err != nil {
+		return err
 	}
+	defer body.Close()
+
+	return g.opts.Sink.WriteFile(outputPath, body, executable)
+}
+
+// gitHubArchiveSource requests the repository tarball at the configured ref
+// (or the default branch when none is set).
+var gitHubArchiveSource = archiveSource{
+	provider: "GitHub",
+	request: func(opts model.DownloadOptions) (*http.Request, error) {
+		host, owner, repo, err := parseGitHubURL(opts.RepoURL)
+		if err != nil {
+			return nil, &errors.AppError{
+				Err:     errors.ErrInvalidURL,
+				Message: "Invalid GitHub URL format",
+				Hint:    "URL should be in the format: https://github.com/owner/repo (use --provider for other hosts)",
+			}
+		}
+
+		baseURL := opts.APIURL
+		if baseURL == "" {
+			baseURL = gitHubAPIBaseURL(host)
+		}
+
+		apiURL := fmt.Sprintf("%s/repos/%s/%s/tarball", strings.TrimSuffix(baseURL, "/"), owner, repo)
+		if opts.Branch != "" {
+			apiURL += "/" + escapePath(opts.Branch)
+		}
 
-	return util.SaveToFile(outputPath, resp.Body)
+		return util.NewGitHubRequest("GET", apiURL, opts.Token)
+	},
 }
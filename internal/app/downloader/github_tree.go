@@ -0,0 +1,221 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+	"github.com/dagimg-dot/gitsnip/internal/util"
+)
+
+const (
+	gitModeExecutable = "100755"
+	gitModeSymlink    = "120000"
+)
+
+type GitHubTreeEntry struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+	Size int64  `json:"size"`
+}
+
+type gitHubTree struct {
+	SHA       string            `json:"sha"`
+	Tree      []GitHubTreeEntry `json:"tree"`
+	Truncated bool              `json:"truncated"`
+}
+
+// downloadTree lists the whole subtree with one recursive Git Trees call and
+// then fetches the files from the raw host. When GitHub truncates the listing it falls back to
+// walking the directories with the Contents API.
+func (g *gitHubAPIDownloader) downloadTree(owner, repo string) error {
+	entry, err := g.resolvePath(owner, repo, g.opts.Subdir)
+	if err != nil {
+		return err
+	}
+
+	if entry.Type == "file" {
+		return g.downloadSingleFile(owner, repo, entry)
+	}
+
+	if err := g.opts.Sink.MkdirAll(g.opts.OutputDir); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	tree, err := g.getTree(owner, repo, entry.SHA, true)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range tree.Tree {
+		if entry.Path == upstreamIgnoreFile && entry.Type == "blob" {
+			fileURL := g.rawURL(path.Join(g.opts.Subdir, entry.Path))
+			err := applyUpstreamIgnore(&g.opts, func(ctx context.Context) (io.ReadCloser, error) {
+				return g.openFile(ctx, fileURL)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if tree.Truncated {
+		if !g.opts.Quiet {
+			fmt.Fprintln(os.Stderr, "Tree listing was truncated, falling back to per-directory listing...")
+		}
+		return g.downloadDirectory(owner, repo, g.opts.Subdir, entry.SHA, g.opts.OutputDir)
+	}
+
+	prefix := strings.Trim(g.opts.Subdir, "/")
+	var tasks []fileTask
+	for _, entry := range tree.Tree {
+		targetPath, err := safepath.Join(g.opts.OutputDir, entry.Path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.Type == "tree":
+			if g.opts.Filter.Match(entry.Path, true) {
+				if err := g.opts.Sink.MkdirAll(targetPath); err != nil {
+					return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+				}
+			}
+		case entry.Type == "blob" && entry.Mode != gitModeSymlink && g.opts.Filter.Match(entry.Path, false):
+			fileURL := g.rawURL(path.Join(prefix, entry.Path))
+			executable := entry.Mode == gitModeExecutable
+			tasks = append(tasks, fileTask{
+				repoPath: path.Join(prefix, entry.Path),
+				fetch: func(ctx context.Context) error {
+					return g.downloadFile(ctx, fileURL, targetPath, executable)
+				},
+			})
+		case entry.Type == "blob" && entry.Mode == gitModeSymlink && g.opts.Symlinks != output.SymlinksSkip && g.opts.Filter.Match(entry.Path, false):
+			// Links are rare, their target is read from the blob itself.
+			blobURL := fmt.Sprintf("%s/repos/%s/%s/git/blobs/%s", g.baseURL, owner, repo, entry.SHA)
+			relPath := entry.Path
+			tasks = append(tasks, fileTask{
+				repoPath: path.Join(prefix, entry.Path),
+				fetch: func(ctx context.Context) error {
+					return downloadSymlink(ctx, g.opts.Sink, relPath, targetPath, func(ctx context.Context) (io.ReadCloser, error) {
+						return g.openFile(ctx, blobURL)
+					})
+				},
+			})
+		}
+	}
+
+	return downloadFiles(tasks, g.opts.Concurrency, g.opts.Quiet)
+}
+
+// downloadSingleFile fetches the blob of a file. The Contents API does not
+// report file modes, so the executable bit is read from the parent tree.
+func (g *gitHubAPIDownloader) downloadSingleFile(owner, repo string, item GitHubContentItem) error {
+	parent, err := g.resolvePath(owner, repo, path.Dir(item.Path))
+	if err != nil {
+		return err
+	}
+	parentTree, err := g.getTree(owner, repo, parent.SHA, false)
+	if err != nil {
+		return err
+	}
+
+	executable := false
+	for _, entry := range parentTree.Tree {
+		if entry.Path == item.Name {
+			executable = entry.Mode == gitModeExecutable
+			break
+		}
+	}
+
+	targetPath, err := singleFilePath(g.opts.Sink, g.opts.OutputDir, item.Path)
+	if err != nil {
+		return err
+	}
+	if err := g.downloadFile(context.Background(), g.rawURL(item.Path), targetPath, executable); err != nil {
+		return err
+	}
+
+	if !g.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloaded %s to %s\n", item.Path, targetPath)
+	}
+	return nil
+}
+
+// resolvePath returns the Contents API entry for repoPath. The repository
+// root is addressed by the ref itself, other paths are looked up in the
+// listing of their parent.
+func (g *gitHubAPIDownloader) resolvePath(owner, repo, repoPath string) (GitHubContentItem, error) {
+	repoPath = strings.Trim(repoPath, "/")
+	if repoPath == "" || repoPath == "." {
+		ref := g.opts.Branch
+		if ref == "" {
+			ref = "HEAD"
+		}
+		return GitHubContentItem{Type: "dir", SHA: ref}, nil
+	}
+
+	parent := path.Dir(repoPath)
+	if parent == "." {
+		parent = ""
+	}
+
+	items, err := g.getContents(owner, repo, parent)
+	if err != nil {
+		return GitHubContentItem{}, err
+	}
+
+	for _, item := range items {
+		if item.Path == repoPath && (item.Type == "dir" || item.Type == "file") {
+			return item, nil
+		}
+	}
+
+	return GitHubContentItem{}, pathNotFoundError(repoPath)
+}
+
+func (g *gitHubAPIDownloader) getTree(owner, repo, treeSHA string, recursive bool) (*gitHubTree, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s",
+		g.baseURL, owner, repo, escapePath(treeSHA))
+	if recursive {
+		apiURL += "?recursive=1"
+	}
+
+	req, err := util.NewGitHubRequest("GET", apiURL, g.opts.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to GitHub API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return nil, errors.ParseGitHubAPIError(resp.StatusCode, bodyStr)
+	}
+
+	var tree gitHubTree
+	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return &tree, nil
+}
@@ -0,0 +1,427 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+	"github.com/dagimg-dot/gitsnip/internal/util"
+)
+
+const (
+	GitLabAPIPath     = "/api/v4"
+	gitLabTreePerPage = 100
+)
+
+type GitLabTreeItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+func NewGitLabAPIDownloader(opts model.DownloadOptions) Downloader {
+	return &gitLabAPIDownloader{
+		opts:   opts,
+		client: util.NewHTTPClient(opts.Token),
+	}
+}
+
+type gitLabAPIDownloader struct {
+	opts   model.DownloadOptions
+	client *http.Client
+	// gitmodulesContent is the commit's .gitmodules, read for
+	// RecurseSubmodules.
+	gitmodulesContent []byte
+}
+
+func (g *gitLabAPIDownloader) Download() error {
+	baseURL, project, err := parseGitLabURL(g.opts.RepoURL)
+	if err != nil {
+		return &errors.AppError{
+			Err:     errors.ErrInvalidURL,
+			Message: "Invalid GitLab URL format",
+			Hint:    "URL should be in the format: https://gitlab.com/group/project",
+		}
+	}
+
+	if g.opts.APIURL != "" {
+		baseURL = strings.TrimSuffix(g.opts.APIURL, "/")
+	}
+
+	if err := resolveBranch(&g.opts, gitLabRefs{g, baseURL, project}); err != nil {
+		return err
+	}
+
+	if g.opts.RecurseSubmodules {
+		g.gitmodulesContent, err = readGitmodules(func(ctx context.Context) (io.ReadCloser, error) {
+			return g.openFile(ctx, baseURL, project, gitmodulesFile)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return forEachTarget(&g.opts, func() error {
+		return g.downloadPath(baseURL, project)
+	})
+}
+
+func (g *gitLabAPIDownloader) gitmodules() []byte {
+	return g.gitmodulesContent
+}
+
+// downloadPath downloads Subdir, a directory or a single file, to OutputDir.
+func (g *gitLabAPIDownloader) downloadPath(baseURL, project string) error {
+	if !g.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloading %s from %s (ref: %s)...\n",
+			g.opts.Subdir, project, g.opts.Branch)
+	}
+
+	items, err := g.getTree(baseURL, project, g.opts.Subdir, true)
+	if err != nil {
+		// The tree endpoint only lists directories, a file is found in the
+		// listing of its parent.
+		if file, ok := g.findFile(baseURL, project, g.opts.Subdir); ok {
+			return g.downloadSingleFile(baseURL, project, file)
+		}
+		return err
+	}
+
+	if err := g.opts.Sink.MkdirAll(g.opts.OutputDir); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	prefix := strings.Trim(g.opts.Subdir, "/")
+	ignorePath := path.Join(prefix, upstreamIgnoreFile)
+	for _, item := range items {
+		if item.Path == ignorePath && item.Type == "blob" {
+			err := applyUpstreamIgnore(&g.opts, func(ctx context.Context) (io.ReadCloser, error) {
+				return g.openFile(ctx, baseURL, project, ignorePath)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var tasks []fileTask
+	for _, item := range items {
+		relPath := strings.TrimPrefix(strings.TrimPrefix(item.Path, prefix), "/")
+		targetPath, err := safepath.Join(g.opts.OutputDir, relPath)
+		if err != nil {
+			return err
+		}
+
+		if item.Type == "tree" {
+			if g.opts.Filter.Match(relPath, true) {
+				if err := g.opts.Sink.MkdirAll(targetPath); err != nil {
+					return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+				}
+			}
+		} else if item.Type == "blob" && item.Mode == gitModeSymlink {
+			if g.opts.Symlinks == output.SymlinksSkip || !g.opts.Filter.Match(relPath, false) {
+				continue
+			}
+			filePath := item.Path
+			tasks = append(tasks, fileTask{
+				repoPath: filePath,
+				fetch: func(ctx context.Context) error {
+					return downloadSymlink(ctx, g.opts.Sink, relPath, targetPath, func(ctx context.Context) (io.ReadCloser, error) {
+						return g.openFile(ctx, baseURL, project, filePath)
+					})
+				},
+			})
+		} else if item.Type == "blob" && g.opts.Filter.Match(relPath, false) {
+			filePath := item.Path
+			executable := item.Mode == gitModeExecutable
+			tasks = append(tasks, fileTask{
+				repoPath: filePath,
+				fetch: func(ctx context.Context) error {
+					return g.downloadFile(ctx, baseURL, project, filePath, targetPath, executable)
+				},
+			})
+		}
+	}
+
+	return downloadFiles(tasks, g.opts.Concurrency, g.opts.Quiet)
+}
+
+// parseGitLabURL splits a GitLab repository URL into the API base URL of the
+// instance and the full project path, which may contain nested groups.
+func parseGitLabURL(repoURL string) (baseURL string, project string, err error) {
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`^(https?)://([^/]+)/(.+?)(?:\.git)?/?$`),
+		regexp.MustCompile(`^git@([^:]+):(.+?)(?:\.git)?$`),
+		regexp.MustCompile(`^([^/:]+\.[^/:]+)/(.+?)(?:\.git)?/?$`),
+	}
+
+	if matches := patterns[0].FindStringSubmatch(repoURL); matches != nil {
+		baseURL, project = matches[1]+"://"+matches[2], matches[3]
+	} else if matches := patterns[1].FindStringSubmatch(repoURL); matches != nil {
+		baseURL, project = "https://"+matches[1], matches[2]
+	} else if matches := patterns[2].FindStringSubmatch(repoURL); matches != nil {
+		baseURL, project = "https://"+matches[1], matches[2]
+	}
+
+	if project == "" || !strings.Contains(project, "/") {
+		return "", "", fmt.Errorf("URL does not match GitLab repository pattern: %s", repoURL)
+	}
+
+	return baseURL + GitLabAPIPath, project, nil
+}
+
+// findFile looks filePath up in the listing of its parent directory and
+// reports whether it is a regular file.
+func (g *gitLabAPIDownloader) findFile(baseURL, project, filePath string) (GitLabTreeItem, bool) {
+	filePath = strings.Trim(filePath, "/")
+	parent := path.Dir(filePath)
+	if parent == "." {
+		parent = ""
+	}
+
+	items, err := g.getTree(baseURL, project, parent, false)
+	if err != nil {
+		return GitLabTreeItem{}, false
+	}
+
+	for _, item := range items {
+		if item.Path == filePath && item.Type == "blob" && item.Mode != gitModeSymlink {
+			return item, true
+		}
+	}
+	return GitLabTreeItem{}, false
+}
+
+func (g *gitLabAPIDownloader) downloadSingleFile(baseURL, project string, file GitLabTreeItem) error {
+	targetPath, err := singleFilePath(g.opts.Sink, g.opts.OutputDir, file.Path)
+	if err != nil {
+		return err
+	}
+	if err := g.downloadFile(context.Background(), baseURL, project, file.Path, targetPath, file.Mode == gitModeExecutable); err != nil {
+		return err
+	}
+
+	if !g.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloaded %s to %s\n", file.Path, targetPath)
+	}
+	return nil
+}
+
+// getTree lists the entries below path, following GitLab's page based
+// pagination until the X-Next-Page header is empty.
+func (g *gitLabAPIDownloader) getTree(baseURL, project, path string, recursive bool) ([]GitLabTreeItem, error) {
+	var items []GitLabTreeItem
+
+	page := "1"
+	for page != "" {
+		query := url.Values{}
+		query.Set("recursive", strconv.FormatBool(recursive))
+		query.Set("per_page", fmt.Sprint(gitLabTreePerPage))
+		query.Set("page", page)
+		if path != "" {
+			query.Set("path", strings.Trim(path, "/"))
+		}
+		if g.opts.Branch != "" {
+			query.Set("ref", g.opts.Branch)
+		}
+
+		apiURL := fmt.Sprintf("%s/projects/%s/repository/tree?%s",
+			baseURL, url.PathEscape(project), query.Encode())
+
+		var pageItems []GitLabTreeItem
+		header, err := g.getJSON(apiURL, &pageItems)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, pageItems...)
+		page = header.Get("X-Next-Page")
+	}
+
+	if len(items) == 0 {
+		return nil, &errors.AppError{
+			Err:     errors.ErrPathNotFound,
+			Message: fmt.Sprintf("Directory '%s' not found in the repository", path),
+			Hint:    "Check that the folder path exists in the specified branch",
+		}
+	}
+
+	return items, nil
+}
+
+// resolveCommit returns the full SHA of the commit ref points to. The
+// commits endpoint accepts branches, tags and abbreviated SHAs.
+func (g *gitLabAPIDownloader) resolveCommit(baseURL, project, ref string) (string, error) {
+	var commit struct {
+		ID string `json:"id"`
+	}
+	apiURL := fmt.Sprintf("%s/projects/%s/repository/commits/%s",
+		baseURL, url.PathEscape(project), url.PathEscape(ref))
+	if _, err := g.getJSON(apiURL, &commit); err != nil {
+		var appErr *errors.AppError
+		if stderrors.As(err, &appErr) && appErr.StatusCode == http.StatusNotFound {
+			return "", refNotFoundError(ref)
+		}
+		return "", err
+	}
+
+	return commit.ID, nil
+}
+
+// gitLabRefs resolves refs of one project for resolveBranch.
+type gitLabRefs struct {
+	d                *gitLabAPIDownloader
+	baseURL, project string
+}
+
+func (r gitLabRefs) defaultBranch() (string, error) {
+	return r.d.getDefaultBranch(r.baseURL, r.project)
+}
+
+func (r gitLabRefs) resolveCommit(ref string) (string, error) {
+	return r.d.resolveCommit(r.baseURL, r.project, ref)
+}
+
+// getDefaultBranch reads the default branch from the project metadata.
+func (g *gitLabAPIDownloader) getDefaultBranch(baseURL, project string) (string, error) {
+	var meta struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if _, err := g.getJSON(fmt.Sprintf("%s/projects/%s", baseURL, url.PathEscape(project)), &meta); err != nil {
+		return "", err
+	}
+
+	return meta.DefaultBranch, nil
+}
+
+func (g *gitLabAPIDownloader) getJSON(apiURL string, v any) (http.Header, error) {
+	req, err := util.NewGitLabRequest("GET", apiURL, g.opts.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to GitLab API",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return nil, errors.ParseGitLabAPIError(resp.StatusCode, bodyStr)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return resp.Header, nil
+}
+
+// openFile requests the raw content of a file.
+func (g *gitLabAPIDownloader) openFile(ctx context.Context, baseURL, project, filePath string) (io.ReadCloser, error) {
+	ref := g.opts.Branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	fileURL := fmt.Sprintf("%s/projects/%s/repository/files/%s/raw?ref=%s",
+		baseURL, url.PathEscape(project), url.PathEscape(filePath), url.QueryEscape(ref))
+
+	req, err := util.NewGitLabRequest("GET", fileURL, g.opts.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to download file",
+			Hint:    "Check your internet connection and try again",
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := strings.TrimSpace(string(body))
+		return nil, errors.ParseGitLabAPIError(resp.StatusCode, bodyStr)
+	}
+
+	return resp.Body, nil
+}
+
+// downloadFile saves the file to outputPath in the output sink.
+func (g *gitLabAPIDownloader) downloadFile(ctx context.Context, baseURL, project, filePath, outputPath string, executable bool) error {
+	body, err := g.openFile(ctx, baseURL, project, filePath)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return g.opts.Sink.WriteFile(outputPath, body, executable)
+}
+
+// gitLabArchiveSource requests a tarball limited to the requested path when
+// there is only one, which GitLab can filter on the server side.
+var gitLabArchiveSource = archiveSource{
+	provider: "GitLab",
+	request: func(opts model.DownloadOptions) (*http.Request, error) {
+		baseURL, project, err := parseGitLabURL(opts.RepoURL)
+		if err != nil {
+			return nil, &errors.AppError{
+				Err:     errors.ErrInvalidURL,
+				Message: "Invalid GitLab URL format",
+				Hint:    "URL should be in the format: https://gitlab.com/group/project",
+			}
+		}
+
+		if opts.APIURL != "" {
+			baseURL = strings.TrimSuffix(opts.APIURL, "/")
+		}
+
+		query := url.Values{}
+		if opts.Branch != "" {
+			query.Set("sha", opts.Branch)
+		}
+		// The archive can only be limited to one path, several paths need
+		// the whole tree.
+		if targets := opts.Targets(); len(targets) == 1 {
+			if subdir := strings.Trim(targets[0].Subdir, "/"); subdir != "" {
+				query.Set("path", subdir)
+			}
+		}
+
+		apiURL := fmt.Sprintf("%s/projects/%s/repository/archive.tar.gz?%s",
+			baseURL, url.PathEscape(project), query.Encode())
+
+		req, err := util.NewGitLabRequest("GET", apiURL, opts.Token)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Del("Accept")
+		return req, nil
+	},
+}
@@ -0,0 +1,50 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/pathfilter"
+)
+
+// upstreamIgnoreFile lets repository authors list, in gitignore syntax, the
+// files of a folder that should not be handed out when the folder is
+// downloaded. Only the file at the top of the downloaded folder is read.
+const upstreamIgnoreFile = ".gitsnipignore"
+
+// upstreamFilter extends filter by the content of the .gitsnipignore of
+// subdir. The ignore file itself is left out as well.
+func upstreamFilter(filter *pathfilter.Filter, subdir string, content []byte, quiet bool) *pathfilter.Filter {
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Applying %s\n", path.Join(strings.Trim(subdir, "/"), upstreamIgnoreFile))
+	}
+	lines := strings.Split(string(content), "\n")
+	return filter.WithIgnore(append(lines, "/"+upstreamIgnoreFile))
+}
+
+// applyUpstreamIgnore reads the .gitsnipignore of the folder being
+// downloaded from open and extends opts.Filter by it.
+func applyUpstreamIgnore(opts *model.DownloadOptions, open func(ctx context.Context) (io.ReadCloser, error)) error {
+	if opts.NoUpstreamIgnore {
+		return nil
+	}
+
+	body, err := open(context.Background())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", upstreamIgnoreFile, err)
+	}
+
+	opts.Filter = upstreamFilter(opts.Filter, opts.Subdir, content, opts.Quiet)
+	return nil
+}
@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+)
+
+// forEachTarget runs download once for every requested path, with Subdir and
+// OutputDir of opts set to that path. It serves the API downloaders, which
+// fetch per path anyway, once the ref has been resolved. Each path starts
+// from the configured filter, before any .gitsnipignore.
+func forEachTarget(opts *model.DownloadOptions, download func() error) error {
+	filter := opts.Filter
+	for _, target := range opts.Targets() {
+		opts.Subdir, opts.OutputDir, opts.Filter = target.Subdir, target.OutputDir, filter
+		if err := download(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetsLabel lists the requested paths for progress messages.
+func targetsLabel(opts model.DownloadOptions) string {
+	var paths []string
+	for _, target := range opts.Targets() {
+		paths = append(paths, target.Subdir)
+	}
+	return strings.Join(paths, ", ")
+}
+
+// relativePath returns repoPath relative to the downloaded directory subdir,
+// the path filters match against.
+func relativePath(repoPath, subdir string) string {
+	repoPath = strings.Trim(repoPath, "/")
+	if prefix := strings.Trim(subdir, "/"); prefix != "" {
+		repoPath = strings.TrimPrefix(strings.TrimPrefix(repoPath, prefix), "/")
+	}
+	return repoPath
+}
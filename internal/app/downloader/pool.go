@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileTask is a single file fetch planned by a downloader. repoPath is only
+// used for progress output and error messages.
+type fileTask struct {
+	repoPath string
+	fetch    func(ctx context.Context) error
+}
+
+// downloadFiles runs tasks on a bounded pool of workers. The first failing
+// task cancels the shared context so in-flight requests are aborted and no
+// further tasks are started. Progress is reported in task order regardless of
+// the order in which downloads finish.
+func downloadFiles(tasks []fileTask, concurrency int, quiet bool) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(tasks) {
+		concurrency = len(tasks)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	report := newProgressReporter(tasks, quiet)
+	jobs := make(chan int)
+
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := tasks[i].fetch(ctx); err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("failed to download file %s: %w", tasks[i].repoPath, err)
+						cancel()
+					})
+					continue
+				}
+				report.done(i)
+			}
+		}()
+	}
+
+feed:
+	for i := range tasks {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+// progressReporter prints finished tasks in their original order, holding
+// back any task that completes before its predecessors.
+type progressReporter struct {
+	mu       sync.Mutex
+	tasks    []fileTask
+	finished []bool
+	next     int
+	quiet    bool
+}
+
+func newProgressReporter(tasks []fileTask, quiet bool) *progressReporter {
+	return &progressReporter{
+		tasks:    tasks,
+		finished: make([]bool, len(tasks)),
+		quiet:    quiet,
+	}
+}
+
+func (p *progressReporter) done(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.finished[i] = true
+	for p.next < len(p.tasks) && p.finished[p.next] {
+		if !p.quiet {
+			fmt.Fprintf(os.Stderr, "Downloaded [%d/%d] %s\n", p.next+1, len(p.tasks), p.tasks[p.next].repoPath)
+		}
+		p.next++
+	}
+}
@@ -0,0 +1,55 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+var (
+	fullCommitPattern  = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+	shortCommitPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,39}$`)
+)
+
+func refNotFoundError(ref string) error {
+	return &errors.AppError{
+		Err:     errors.ErrPathNotFound,
+		Message: fmt.Sprintf("Reference '%s' not found in the repository", ref),
+		Hint:    "Check the tag, branch or commit SHA; abbreviated SHAs must be unique and at least 4 characters",
+	}
+}
+
+// refResolver looks up refs of one repository through a provider's API.
+type refResolver interface {
+	defaultBranch() (string, error)
+	resolveCommit(ref string) (string, error)
+}
+
+// resolveBranch points opts.Branch at the commit Ref resolves to, so every
+// later request addresses that commit, or at the default branch when
+// neither Ref nor Branch is set.
+func resolveBranch(opts *model.DownloadOptions, refs refResolver) error {
+	if opts.Ref != "" {
+		commit, err := refs.resolveCommit(opts.Ref)
+		if err != nil {
+			return err
+		}
+		opts.Branch = commit
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Resolved %s to commit %s\n", opts.Ref, commit)
+		}
+	} else if opts.Branch == "" {
+		branch, err := refs.defaultBranch()
+		if err != nil {
+			return err
+		}
+		opts.Branch = branch
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Using default branch: %s\n", branch)
+		}
+	}
+	return nil
+}
@@ -2,20 +2,30 @@ package downloader
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
-	"time"
 
+	"github.com/dagimg-dot/gitsnip/internal/app/gitproto"
 	"github.com/dagimg-dot/gitsnip/internal/app/gitutil"
 	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/pathfilter"
 	"github.com/dagimg-dot/gitsnip/internal/errors"
-	"github.com/dagimg-dot/gitsnip/internal/util"
 )
 
 type sparseCheckoutDownloader struct {
 	opts model.DownloadOptions
+
+	// commit is the resolved hash of opts.Ref.
+	commit string
+
+	// sparseIndex is set when git supports a sparse index, which keeps
+	// checkout from reading trees outside the cone.
+	sparseIndex bool
 }
 
 func NewSparseCheckoutDownloader(opts model.DownloadOptions) Downloader {
@@ -23,26 +33,29 @@ func NewSparseCheckoutDownloader(opts model.DownloadOptions) Downloader {
 }
 
 func (s *sparseCheckoutDownloader) Download() error {
-	if !gitutil.IsGitInstalled() {
-		return &errors.AppError{
-			Err:     errors.ErrGitNotInstalled,
-			Message: "Git is not installed on this system",
-			Hint:    "Please install Git to use the sparse checkout method",
+	if !s.opts.Quiet {
+		if s.opts.Ref != "" {
+			fmt.Fprintf(os.Stderr, "Downloading %s from %s (ref: %s) using sparse checkout...\n",
+				targetsLabel(s.opts), s.opts.RepoURL, s.opts.Ref)
+		} else if s.opts.Branch == "" {
+			fmt.Fprintf(os.Stderr, "Downloading %s from %s (default branch) using sparse checkout...\n",
+				targetsLabel(s.opts), s.opts.RepoURL)
+		} else {
+			fmt.Fprintf(os.Stderr, "Downloading %s from %s (branch: %s) using sparse checkout...\n",
+				targetsLabel(s.opts), s.opts.RepoURL, s.opts.Branch)
 		}
 	}
 
-	if err := util.EnsureDir(s.opts.OutputDir); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
-	}
+	repoURL := gitutil.AuthenticatedURL(s.opts.RepoURL, s.opts.Token, s.opts.Provider)
 
-	if !s.opts.Quiet {
-		if s.opts.Branch == "" {
-			fmt.Printf("Downloading directory %s from %s (default branch) using sparse checkout...\n",
-				s.opts.Subdir, s.opts.RepoURL)
-		} else {
-			fmt.Printf("Downloading directory %s from %s (branch: %s) using sparse checkout...\n",
-				s.opts.Subdir, s.opts.RepoURL, s.opts.Branch)
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.GitTimeout())
+	defer cancel()
+
+	if !gitutil.IsGitInstalled() {
+		if !s.opts.Quiet {
+			fmt.Fprintln(os.Stderr, "Git is not installed, using the built-in HTTP transport...")
 		}
+		return s.downloadOverHTTP(ctx, repoURL)
 	}
 
 	tempDir, err := gitutil.CreateTempDir()
@@ -51,107 +64,426 @@ func (s *sparseCheckoutDownloader) Download() error {
 	}
 	defer gitutil.CleanupTempDir(tempDir)
 
-	repoURL := s.getAuthenticatedRepoURL()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
-
 	if err := s.initRepo(ctx, tempDir, repoURL); err != nil {
 		return err
 	}
 
+	if s.opts.Ref != "" {
+		commit, err := s.resolveRef(ctx, tempDir, repoURL)
+		if err != nil {
+			return err
+		}
+		s.commit = commit
+		if !s.opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Resolved %s to commit %s\n", s.opts.Ref, commit)
+		}
+	} else if s.opts.Branch == "" {
+		branch, err := s.getDefaultBranch(ctx, tempDir)
+		if err != nil {
+			return err
+		}
+		s.opts.Branch = branch
+		if !s.opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Using default branch: %s\n", branch)
+		}
+	}
+
 	if err := s.setupSparseCheckout(ctx, tempDir); err != nil {
 		return err
 	}
 
-	if err := s.pullContent(ctx, tempDir); err != nil {
+	if err := s.fetchContent(ctx, tempDir, repoURL); err != nil {
 		return err
 	}
 
-	sparsePath := filepath.Join(tempDir, s.opts.Subdir)
-	if _, err := os.Stat(sparsePath); os.IsNotExist(err) {
-		return &errors.AppError{
-			Err:     errors.ErrPathNotFound,
-			Message: fmt.Sprintf("Directory '%s' not found in the repository", s.opts.Subdir),
-			Hint:    "Check that the folder path exists in the repository",
+	// Files are read straight from the fetched commit, only directories
+	// go through the sparse checkout.
+	var dirs []model.PathSpec
+	for _, target := range s.opts.Targets() {
+		mode, objectType, err := s.treeEntry(ctx, tempDir, target.Subdir)
+		if err != nil {
+			return err
+		}
+		switch objectType {
+		case "tree":
+			dirs = append(dirs, target)
+		case "blob":
+			if err := s.writeSingleFile(ctx, tempDir, target, mode); err != nil {
+				return err
+			}
+		default:
+			return pathNotFoundError(target.Subdir)
+		}
+	}
+
+	if len(dirs) > 0 {
+		if err := s.checkoutDirectories(ctx, tempDir, dirs); err != nil {
+			return err
 		}
 	}
 
 	if !s.opts.Quiet {
-		fmt.Printf("Copying files to %s...\n", s.opts.OutputDir)
+		fmt.Fprintln(os.Stderr, "Download completed successfully.")
 	}
+	return nil
+}
 
-	if err := util.CopyDirectory(sparsePath, s.opts.OutputDir); err != nil {
-		return fmt.Errorf("failed to copy directory: %w", err)
+func (s *sparseCheckoutDownloader) initRepo(ctx context.Context, dir, repoURL string) error {
+	if _, err := gitutil.RunGitCommand(ctx, dir, "init"); err != nil {
+		return errors.ParseGitError(err, "git init failed")
 	}
 
-	if !s.opts.Quiet {
-		fmt.Println("Download completed successfully.")
+	if _, err := gitutil.RunGitCommand(ctx, dir, "remote", "add", "origin", repoURL); err != nil {
+		return errors.ParseGitError(err, "failed to add remote")
 	}
+
 	return nil
 }
 
-func (s *sparseCheckoutDownloader) getAuthenticatedRepoURL() string {
-	repoURL := s.opts.RepoURL
+// getDefaultBranch asks the remote which branch its HEAD points to.
+func (s *sparseCheckoutDownloader) getDefaultBranch(ctx context.Context, dir string) (string, error) {
+	output, err := gitutil.RunGitCommand(ctx, dir, "ls-remote", "--symref", "origin", "HEAD")
+	if err != nil {
+		return "", errors.ParseGitError(err, "failed to query the default branch")
+	}
+
+	// ref: refs/heads/main	HEAD
+	for _, line := range strings.Split(output, "\n") {
+		if target, ok := strings.CutPrefix(line, "ref: "); ok {
+			target, _, _ = strings.Cut(target, "\t")
+			return strings.TrimPrefix(target, "refs/heads/"), nil
+		}
+	}
+
+	return "", &errors.AppError{
+		Err:     errors.ErrPathNotFound,
+		Message: "Could not determine the default branch of the repository",
+		Hint:    "Use --branch to select a branch",
+	}
+}
+
+// resolveRef returns the full commit hash of Ref. Names are looked up on the
+// remote in the order git rev-parse uses, abbreviated hashes are expanded
+// with expandCommit.
+func (s *sparseCheckoutDownloader) resolveRef(ctx context.Context, dir, repoURL string) (string, error) {
+	ref := strings.TrimSpace(s.opts.Ref)
+	if fullCommitPattern.MatchString(ref) {
+		return strings.ToLower(ref), nil
+	}
 
-	if strings.HasPrefix(repoURL, "github.com/") {
-		repoURL = "https://" + repoURL
+	output, err := gitutil.RunGitCommand(ctx, dir, "ls-remote", "origin", ref, ref+"^{}")
+	if err != nil {
+		return "", errors.ParseGitError(err, "failed to list remote references")
 	}
 
-	if s.opts.Token == "" {
-		return repoURL
+	hashes := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if hash, name, ok := strings.Cut(line, "\t"); ok {
+			hashes[name] = hash
+		}
 	}
 
-	if strings.HasPrefix(repoURL, "https://") {
-		parts := strings.SplitN(repoURL[8:], "/", 2)
-		if len(parts) == 2 {
-			return fmt.Sprintf("https://%s@%s/%s", s.opts.Token, parts[0], parts[1])
+	candidates := []string{ref}
+	if !strings.HasPrefix(ref, "refs/") {
+		candidates = []string{"refs/" + ref, "refs/tags/" + ref, "refs/heads/" + ref}
+	}
+	for _, candidate := range candidates {
+		// Annotated tags are listed a second time, peeled to their commit.
+		if hash, ok := hashes[candidate+"^{}"]; ok {
+			return hash, nil
+		}
+		if hash, ok := hashes[candidate]; ok {
+			return hash, nil
 		}
 	}
 
-	return repoURL
+	if shortCommitPattern.MatchString(ref) {
+		return s.expandCommit(ctx, repoURL, ref)
+	}
+
+	return "", refNotFoundError(ref)
 }
 
-func (s *sparseCheckoutDownloader) initRepo(ctx context.Context, dir, repoURL string) error {
-	if _, err := gitutil.RunGitCommand(ctx, dir, "init"); err != nil {
-		return errors.ParseGitError(err, "git init failed")
+// expandCommit expands an abbreviated commit hash from the commit history of
+// all branches and tags. The history is fetched into a separate repository,
+// without trees and blobs, so it does not end up in the checkout.
+func (s *sparseCheckoutDownloader) expandCommit(ctx context.Context, repoURL, ref string) (string, error) {
+	dir, err := gitutil.CreateTempDir()
+	if err != nil {
+		return "", err
 	}
+	defer gitutil.CleanupTempDir(dir)
 
-	if _, err := gitutil.RunGitCommand(ctx, dir, "remote", "add", "origin", repoURL); err != nil {
-		return errors.ParseGitError(err, "failed to add remote")
+	if err := s.initRepo(ctx, dir, repoURL); err != nil {
+		return "", err
 	}
 
-	return nil
+	if _, err := gitutil.RunGitCommand(ctx, dir, "fetch", "--filter=tree:0", "--tags", "origin"); err != nil {
+		return "", errors.ParseGitError(err, "failed to fetch commit history")
+	}
+
+	output, err := gitutil.RunGitCommand(ctx, dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", refNotFoundError(ref)
+	}
+
+	return strings.TrimSpace(output), nil
 }
 
 func (s *sparseCheckoutDownloader) setupSparseCheckout(ctx context.Context, dir string) error {
-	if _, err := gitutil.RunGitCommand(ctx, dir, "sparse-checkout", "init", "--cone"); err != nil {
+	if s.opts.Filter != nil {
+		// Filters are gitignore style patterns, which cone mode does not
+		// take. Older git has no --no-cone and defaults to it.
+		if _, err := gitutil.RunGitCommand(ctx, dir, "sparse-checkout", "init", "--no-cone"); err == nil {
+			return nil
+		}
+		if _, err := gitutil.RunGitCommand(ctx, dir, "sparse-checkout", "init"); err != nil {
+			return errors.ParseGitError(err, "failed to enable sparse checkout")
+		}
+		return nil
+	}
+
+	// --sparse-index needs git 2.32 or later.
+	if _, err := gitutil.RunGitCommand(ctx, dir, "sparse-checkout", "init", "--cone", "--sparse-index"); err == nil {
+		s.sparseIndex = true
+	} else if _, err := gitutil.RunGitCommand(ctx, dir, "sparse-checkout", "init", "--cone"); err != nil {
 		return errors.ParseGitError(err, "failed to enable sparse checkout")
 	}
 
-	if _, err := gitutil.RunGitCommand(ctx, dir, "sparse-checkout", "set", s.opts.Subdir); err != nil {
-		return errors.ParseGitError(err, "failed to set sparse checkout pattern")
+	return nil
+}
+
+// checkoutDirectories checks out all directories with a single sparse
+// checkout and copies each to its output directory.
+func (s *sparseCheckoutDownloader) checkoutDirectories(ctx context.Context, dir string, targets []model.PathSpec) error {
+	if s.opts.Filter != nil {
+		var patterns []string
+		for _, target := range targets {
+			patterns = append(patterns, s.opts.Filter.SparsePatterns(target.Subdir)...)
+		}
+		input := strings.Join(patterns, "\n") + "\n"
+		if _, err := gitutil.RunGitCommandWithInput(ctx, dir, input, "sparse-checkout", "set", "--stdin"); err != nil {
+			return errors.ParseGitError(err, "failed to set sparse checkout pattern")
+		}
+	} else {
+		setArgs := []string{"sparse-checkout", "set"}
+		for _, target := range targets {
+			if strings.Trim(target.Subdir, "/") == "" {
+				// The whole repository, as for a submodule.
+				setArgs = []string{"sparse-checkout", "disable"}
+				break
+			}
+			setArgs = append(setArgs, target.Subdir)
+		}
+		if _, err := gitutil.RunGitCommand(ctx, dir, setArgs...); err != nil {
+			return errors.ParseGitError(err, "failed to set sparse checkout pattern")
+		}
+	}
+
+	if _, err := gitutil.RunGitCommand(ctx, dir, "checkout", "FETCH_HEAD"); err != nil {
+		return errors.ParseGitError(err, "failed to checkout content")
+	}
+
+	var links []gitlink
+	for _, target := range targets {
+		if err := s.opts.Sink.MkdirAll(target.OutputDir); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+
+		targetFilter, err := s.upstreamFilter(ctx, dir, target)
+		if err != nil {
+			return err
+		}
+		if strings.Trim(target.Subdir, "/") == "" {
+			// The checkout's own repository is not part of the download.
+			targetFilter = targetFilter.WithIgnore([]string{"/.git"})
+		}
+
+		if s.opts.RecurseSubmodules {
+			targetLinks, err := s.listGitlinks(ctx, dir, target, targetFilter)
+			if err != nil {
+				return err
+			}
+			links = append(links, targetLinks...)
+		}
+
+		// Missing when the filter left nothing to check out.
+		sparsePath := filepath.Join(dir, target.Subdir)
+		if _, err := os.Stat(sparsePath); os.IsNotExist(err) {
+			continue
+		}
+
+		if !s.opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Copying files to %s...\n", target.OutputDir)
+		}
+
+		var filter output.Filter
+		if targetFilter != nil {
+			filter = targetFilter
+		}
+		if err := output.CopyDirectory(s.opts.Sink, sparsePath, target.OutputDir, filter); err != nil {
+			return fmt.Errorf("failed to copy directory: %w", err)
+		}
 	}
 
+	if len(links) > 0 {
+		return s.downloadSubmodules(ctx, dir, links)
+	}
 	return nil
 }
 
-func (s *sparseCheckoutDownloader) pullContent(ctx context.Context, dir string) error {
-	if !s.opts.Quiet {
-		fmt.Println("Downloading content from repository...")
+// upstreamFilter returns the filter for target, extended by the
+// .gitsnipignore at its top when the fetched commit has one.
+func (s *sparseCheckoutDownloader) upstreamFilter(ctx context.Context, dir string, target model.PathSpec) (*pathfilter.Filter, error) {
+	if s.opts.NoUpstreamIgnore {
+		return s.opts.Filter, nil
+	}
+
+	ignorePath := path.Join(strings.Trim(target.Subdir, "/"), upstreamIgnoreFile)
+	_, objectType, err := s.treeEntry(ctx, dir, ignorePath)
+	if err != nil || objectType != "blob" {
+		return s.opts.Filter, err
 	}
 
-	fetchArgs := []string{"fetch", "--depth=1", "--no-tags", "origin"}
-	if s.opts.Branch != "" {
-		fetchArgs = append(fetchArgs, s.opts.Branch)
+	content, err := gitutil.RunGitCommand(ctx, dir, "cat-file", "blob", "FETCH_HEAD:"+ignorePath)
+	if err != nil {
+		return nil, errors.ParseGitError(err, "failed to read "+upstreamIgnoreFile)
+	}
+	return upstreamFilter(s.opts.Filter, target.Subdir, []byte(content), s.opts.Quiet), nil
+}
+
+func (s *sparseCheckoutDownloader) fetchContent(ctx context.Context, dir, repoURL string) error {
+	if !s.opts.Quiet {
+		fmt.Fprintln(os.Stderr, "Downloading content from repository...")
 	}
-	if _, err := gitutil.RunGitCommand(ctx, dir, fetchArgs...); err != nil {
+
+	if err := s.fetchPartial(ctx, dir, repoURL); err != nil {
 		return errors.ParseGitError(err, "failed to fetch content")
 	}
 
-	if _, err := gitutil.RunGitCommand(ctx, dir, "checkout", "FETCH_HEAD"); err != nil {
-		return errors.ParseGitError(err, "failed to checkout content")
+	return nil
+}
+
+// treeEntry returns the mode and object type of subdir in the fetched
+// commit. objectType is empty when subdir does not exist.
+func (s *sparseCheckoutDownloader) treeEntry(ctx context.Context, dir, subdir string) (mode, objectType string, err error) {
+	repoPath := strings.Trim(subdir, "/")
+	if repoPath == "" {
+		return "", "tree", nil
+	}
+
+	output, err := gitutil.RunGitCommand(ctx, dir, "ls-tree", "FETCH_HEAD", "--", repoPath)
+	if err != nil {
+		return "", "", errors.ParseGitError(err, "failed to read the repository tree")
+	}
+
+	// 100755 blob <hash>	<path>
+	fields := strings.Fields(output)
+	if len(fields) < 2 {
+		return "", "", nil
+	}
+	return fields[0], fields[1], nil
+}
+
+// writeSingleFile writes the blob at the target's path without a checkout.
+// A partial clone fetches just that blob from the promisor remote.
+func (s *sparseCheckoutDownloader) writeSingleFile(ctx context.Context, dir string, target model.PathSpec, mode string) error {
+	repoPath := strings.Trim(target.Subdir, "/")
+	content, err := gitutil.RunGitCommand(ctx, dir, "cat-file", "blob", "FETCH_HEAD:"+repoPath)
+	if err != nil {
+		return errors.ParseGitError(err, "failed to read file content")
+	}
+
+	targetPath, err := singleFilePath(s.opts.Sink, target.OutputDir, repoPath)
+	if err != nil {
+		return err
+	}
+	if err := s.opts.Sink.WriteFile(targetPath, strings.NewReader(content), mode == gitModeExecutable); err != nil {
+		return err
 	}
 
+	if !s.opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloaded %s to %s\n", repoPath, targetPath)
+	}
 	return nil
 }
+
+// fetchPartial fetches the commit as a partial clone so that checkout only
+// downloads the objects inside the sparse cone. Filters the server rejects
+// are dropped one after another, ending with a plain shallow fetch.
+func (s *sparseCheckoutDownloader) fetchPartial(ctx context.Context, dir, repoURL string) error {
+	// Without a sparse index checkout walks every tree of the commit, which
+	// with tree:0 means one round trip per tree.
+	filters := []string{"blob:none", ""}
+	if s.sparseIndex {
+		filters = append([]string{"tree:0"}, filters...)
+	}
+	// A server without filter support would ignore the filter and send
+	// everything, while origin stayed registered as a promisor remote.
+	if !serverSupportsFilter(ctx, repoURL) {
+		filters = []string{""}
+	}
+
+	var err error
+	for i, filter := range filters {
+		fetchArgs := []string{"fetch", "--depth=1", "--no-tags"}
+		if filter != "" {
+			fetchArgs = append(fetchArgs, "--filter="+filter)
+		} else if i > 0 {
+			// Earlier attempts registered origin as a promisor remote.
+			gitutil.RunGitCommand(ctx, dir, "config", "--unset", "remote.origin.promisor")
+			gitutil.RunGitCommand(ctx, dir, "config", "--unset", "remote.origin.partialclonefilter")
+		}
+		fetchArgs = append(fetchArgs, "origin")
+		if s.commit != "" {
+			fetchArgs = append(fetchArgs, s.commit)
+		} else if s.opts.Branch != "" {
+			fetchArgs = append(fetchArgs, s.opts.Branch)
+		}
+
+		_, err = gitutil.RunGitCommand(ctx, dir, fetchArgs...)
+		if err == nil || filter == "" || !isFilterError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// filterRejections are the messages of a server that advertises filters
+// but refuses the one requested, see uploadpackfilter.<filter>.allow.
+var filterRejections = []string{
+	"filter '",
+	"tree filter allows max depth",
+	"filtering not recognized by server",
+}
+
+// isFilterError reports whether a failed fetch was caused by the server
+// rejecting the object filter. Only git's output is looked at, the command
+// line always names the filter.
+func isFilterError(err error) bool {
+	var cmdErr *gitutil.CommandError
+	if !stderrors.As(err, &cmdErr) {
+		return false
+	}
+	output := strings.ToLower(cmdErr.Stderr)
+	for _, rejection := range filterRejections {
+		if strings.Contains(output, rejection) {
+			return true
+		}
+	}
+	return false
+}
+
+// serverSupportsFilter reports whether the remote advertises the filter
+// fetch feature. Only http(s) remotes speaking protocol version 2 can be
+// asked up front, for others a rejected filter is dropped after the fact.
+func serverSupportsFilter(ctx context.Context, repoURL string) bool {
+	if !strings.HasPrefix(repoURL, "https://") && !strings.HasPrefix(repoURL, "http://") {
+		return true
+	}
+	client := gitproto.NewClient(repoURL)
+	if err := client.Connect(ctx); err != nil {
+		return true
+	}
+	return client.SupportsFetchFeature("filter")
+}
@@ -0,0 +1,34 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/gitutil"
+)
+
+func TestIsFilterError(t *testing.T) {
+	args := []string{"fetch", "--depth=1", "--filter=blob:none", "origin", "main"}
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"fatal: git upload-pack: filter 'tree' not supported\n", true},
+		{"fatal: tree filter allows max depth 0, but got 1\n", true},
+		{"fatal: couldn't find remote ref nosuch\n", false},
+		{"fatal: Authentication failed for 'https://example.com/repo/'\n", false},
+		{"fatal: unable to access 'https://example.com/': Could not resolve host\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("fetch failed: %w", &gitutil.CommandError{Args: args, Err: errors.New("exit status 128"), Stderr: tt.stderr})
+		if got := isFilterError(err); got != tt.want {
+			t.Errorf("isFilterError(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+
+	if isFilterError(errors.New("git fetch --filter=blob:none: exit status 128")) {
+		t.Error("isFilterError must not match the command line")
+	}
+}
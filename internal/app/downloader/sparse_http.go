@@ -0,0 +1,341 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/gitproto"
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/pathfilter"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+// blobFile is a file to download whose content is fetched on demand. path
+// is relative to the repository root.
+type blobFile struct {
+	path       string
+	hash       string
+	executable bool
+	targetPath string
+	// link marks a symlink, relPath is its path inside the folder.
+	link    bool
+	relPath string
+}
+
+// downloadOverHTTP fetches the requested paths with the built-in smart HTTP
+// client when no git binary is available. It fetches the commit with a depth
+// of one and without blobs, then requests only the blobs of those paths.
+func (s *sparseCheckoutDownloader) downloadOverHTTP(ctx context.Context, repoURL string) error {
+	if !strings.HasPrefix(repoURL, "https://") && !strings.HasPrefix(repoURL, "http://") {
+		return &errors.AppError{
+			Err:     errors.ErrGitNotInstalled,
+			Message: "Git is not installed on this system",
+			Hint:    "The built-in transport only supports http(s) URLs, install Git to use other remotes",
+		}
+	}
+
+	client := gitproto.NewClient(repoURL)
+	if err := client.Connect(ctx); err != nil {
+		return transportError(err)
+	}
+
+	commit, err := s.resolveCommitOverHTTP(ctx, client)
+	if err != nil {
+		return transportError(err)
+	}
+
+	filter := ""
+	if client.SupportsFetchFeature("filter") {
+		filter = "blob:none"
+	}
+
+	if !s.opts.Quiet {
+		fmt.Fprintln(os.Stderr, "Downloading content from repository...")
+	}
+
+	store, err := client.Fetch(ctx, gitproto.FetchRequest{
+		Wants:  []string{commit},
+		Depth:  1,
+		Filter: filter,
+	})
+	if err != nil {
+		return transportError(err)
+	}
+
+	commitObj, ok := store.Get(commit)
+	if !ok {
+		return fmt.Errorf("commit %s missing from fetched pack", commit)
+	}
+	rootTree, err := gitproto.CommitTree(commitObj)
+	if err != nil {
+		return err
+	}
+
+	var files []blobFile
+	var links []gitlink
+	for _, target := range s.opts.Targets() {
+		filter, err := s.upstreamFilterOverHTTP(ctx, client, store, rootTree, target)
+		if err != nil {
+			return err
+		}
+		targetFiles, err := s.collectBlobs(store, rootTree, target, filter)
+		if err != nil {
+			return err
+		}
+		files = append(files, targetFiles...)
+
+		if s.opts.RecurseSubmodules {
+			targetLinks, err := findGitlinks(store, rootTree, target, filter)
+			if err != nil {
+				return err
+			}
+			links = append(links, targetLinks...)
+		}
+	}
+
+	if err := s.fetchMissingBlobs(ctx, client, store, files); err != nil {
+		return err
+	}
+
+	if !s.opts.Quiet {
+		fmt.Fprintln(os.Stderr, "Writing files...")
+	}
+
+	for _, file := range files {
+		obj, ok := store.Get(file.hash)
+		if !ok {
+			return fmt.Errorf("blob for %s missing from fetched pack", file.path)
+		}
+
+		if file.link {
+			err = writeSymlink(s.opts.Sink, file.relPath, file.targetPath, bytes.NewReader(obj.Data))
+		} else {
+			err = s.opts.Sink.WriteFile(file.targetPath, bytes.NewReader(obj.Data), file.executable)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(links) > 0 {
+		gitmodules, err := s.readGitmodulesOverHTTP(ctx, client, store, rootTree)
+		if err != nil {
+			return err
+		}
+		if err := downloadSubmodules(s.opts, links, gitmodules); err != nil {
+			return err
+		}
+	}
+
+	if !s.opts.Quiet {
+		fmt.Fprintln(os.Stderr, "Download completed successfully.")
+	}
+	return nil
+}
+
+// upstreamFilterOverHTTP returns the filter for target, extended by the
+// .gitsnipignore at its top when the commit has one.
+func (s *sparseCheckoutDownloader) upstreamFilterOverHTTP(ctx context.Context, client *gitproto.Client, store *gitproto.ObjectStore, rootTree string, target model.PathSpec) (*pathfilter.Filter, error) {
+	if s.opts.NoUpstreamIgnore {
+		return s.opts.Filter, nil
+	}
+
+	ignorePath := path.Join(strings.Trim(target.Subdir, "/"), upstreamIgnoreFile)
+	entry, err := store.LookupPath(rootTree, ignorePath)
+	if err != nil || (entry.Mode != gitproto.ModeFile && entry.Mode != gitproto.ModeExecutable) {
+		return s.opts.Filter, nil
+	}
+
+	ignore := blobFile{path: ignorePath, hash: entry.Hash}
+	if err := s.fetchMissingBlobs(ctx, client, store, []blobFile{ignore}); err != nil {
+		return nil, err
+	}
+	obj, ok := store.Get(entry.Hash)
+	if !ok {
+		return nil, fmt.Errorf("blob for %s missing from fetched pack", ignorePath)
+	}
+	return upstreamFilter(s.opts.Filter, target.Subdir, obj.Data, s.opts.Quiet), nil
+}
+
+// collectBlobs lists the files of a target that filter keeps, creating the
+// directory structure below its OutputDir. A target naming a file yields
+// just that file.
+func (s *sparseCheckoutDownloader) collectBlobs(store *gitproto.ObjectStore, rootTree string, target model.PathSpec, filter *pathfilter.Filter) ([]blobFile, error) {
+	prefix := strings.Trim(target.Subdir, "/")
+
+	entry, err := store.LookupPath(rootTree, prefix)
+	if err != nil {
+		return nil, pathNotFoundError(target.Subdir)
+	}
+
+	switch entry.Mode {
+	case gitproto.ModeTree:
+	case gitproto.ModeFile, gitproto.ModeExecutable:
+		targetPath, err := singleFilePath(s.opts.Sink, target.OutputDir, prefix)
+		if err != nil {
+			return nil, err
+		}
+		return []blobFile{{
+			path:       prefix,
+			hash:       entry.Hash,
+			executable: entry.Mode == gitproto.ModeExecutable,
+			targetPath: targetPath,
+		}}, nil
+	default:
+		return nil, pathNotFoundError(target.Subdir)
+	}
+
+	if err := s.opts.Sink.MkdirAll(target.OutputDir); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	var files []blobFile
+	err = store.WalkTree(entry.Hash, func(relPath string, child gitproto.TreeEntry) error {
+		targetPath, err := safepath.Join(target.OutputDir, relPath)
+		if err != nil {
+			return err
+		}
+		switch child.Mode {
+		case gitproto.ModeTree:
+			if filter.Prune(relPath) {
+				return fs.SkipDir
+			}
+			if filter.Match(relPath, true) {
+				if err := s.opts.Sink.MkdirAll(targetPath); err != nil {
+					return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+				}
+			}
+		case gitproto.ModeFile, gitproto.ModeExecutable:
+			if !filter.Match(relPath, false) {
+				return nil
+			}
+			files = append(files, blobFile{
+				path:       path.Join(prefix, relPath),
+				hash:       child.Hash,
+				executable: child.Mode == gitproto.ModeExecutable,
+				targetPath: targetPath,
+			})
+		case gitproto.ModeSymlink:
+			if s.opts.Symlinks == output.SymlinksSkip || !filter.Match(relPath, false) {
+				return nil
+			}
+			files = append(files, blobFile{
+				path:       path.Join(prefix, relPath),
+				hash:       child.Hash,
+				targetPath: targetPath,
+				link:       true,
+				relPath:    relPath,
+			})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// resolveCommitOverHTTP returns the commit to download: Ref when set,
+// otherwise the tip of Branch or of the default branch.
+func (s *sparseCheckoutDownloader) resolveCommitOverHTTP(ctx context.Context, client *gitproto.Client) (string, error) {
+	if s.opts.Ref != "" {
+		commit, err := client.ResolveCommit(ctx, s.opts.Ref)
+		if stderrors.Is(err, gitproto.ErrRefNotFound) {
+			return "", refNotFoundError(s.opts.Ref)
+		}
+		if err != nil {
+			return "", err
+		}
+		if !s.opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Resolved %s to commit %s\n", s.opts.Ref, commit)
+		}
+		return commit, nil
+	}
+
+	if s.opts.Branch == "" {
+		branch, err := client.DefaultBranch(ctx)
+		if err != nil {
+			return "", err
+		}
+		s.opts.Branch = branch
+		if !s.opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Using default branch: %s\n", branch)
+		}
+	}
+
+	return client.ResolveRef(ctx, s.opts.Branch)
+}
+
+// fetchMissingBlobs requests every blob that the filtered fetch left out in
+// a single additional fetch.
+func (s *sparseCheckoutDownloader) fetchMissingBlobs(ctx context.Context, client *gitproto.Client, store *gitproto.ObjectStore, files []blobFile) error {
+	seen := make(map[string]bool)
+	var missing []string
+	for _, file := range files {
+		if _, ok := store.Get(file.hash); ok || seen[file.hash] {
+			continue
+		}
+		seen[file.hash] = true
+		missing = append(missing, file.hash)
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	blobs, err := client.Fetch(ctx, gitproto.FetchRequest{Wants: missing})
+	if err != nil {
+		return transportError(err)
+	}
+	store.Merge(blobs)
+	return nil
+}
+
+// transportError converts errors of the built-in transport to AppErrors.
+func transportError(err error) error {
+	var statusErr *gitproto.StatusError
+	var appErr *errors.AppError
+	switch {
+	case stderrors.As(err, &appErr):
+		return err
+	case stderrors.As(err, &statusErr):
+		return errors.ParseGitHTTPError(statusErr.StatusCode, statusErr.Body)
+	case stderrors.Is(err, gitproto.ErrRefNotFound):
+		return &errors.AppError{
+			Err:     errors.ErrPathNotFound,
+			Message: "Branch or reference not found",
+			Hint:    "Check that the branch name or reference exists in the repository",
+		}
+	case stderrors.Is(err, gitproto.ErrAmbiguousRef):
+		return &errors.AppError{
+			Err:     errors.ErrPathNotFound,
+			Message: "Abbreviated commit SHA is ambiguous",
+			Hint:    "Use a longer or the full commit SHA",
+		}
+	case stderrors.Is(err, context.DeadlineExceeded):
+		return &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Timed out while downloading from the remote repository",
+			Hint:    "Check your internet connection and try again",
+		}
+	case stderrors.As(err, new(*url.Error)):
+		// Not wrapped verbatim, the URL may carry the token.
+		return &errors.AppError{
+			Err:     errors.ErrNetworkFailure,
+			Message: "Failed to connect to remote repository",
+			Hint:    "Check your internet connection and try again",
+		}
+	default:
+		return &errors.AppError{
+			Err:     errors.ErrGitFetchFailed,
+			Message: "Failed to fetch from remote repository",
+			Hint:    fmt.Sprintf("Transport error: %v", err),
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/gitproto"
+	"github.com/dagimg-dot/gitsnip/internal/app/gitutil"
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/pathfilter"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+// gitmodulesFile maps submodule paths to their URLs.
+const gitmodulesFile = ".gitmodules"
+
+// gitlink is a submodule inside a downloaded folder. path is relative to
+// the repository root, commit is the commit the parent records for it.
+type gitlink struct {
+	path      string
+	commit    string
+	outputDir string
+	// filter is the filter of the folder applied below the submodule.
+	filter *pathfilter.Filter
+}
+
+// newGitlink returns the submodule at relPath inside target.
+func newGitlink(target model.PathSpec, relPath, commit string, filter *pathfilter.Filter) (gitlink, error) {
+	outputDir, err := safepath.Join(target.OutputDir, relPath)
+	if err != nil {
+		return gitlink{}, err
+	}
+	return gitlink{
+		path:      path.Join(strings.Trim(target.Subdir, "/"), relPath),
+		commit:    commit,
+		outputDir: outputDir,
+		filter:    filter.Below(relPath),
+	}, nil
+}
+
+// pruned reports whether filter leaves out everything below the directory
+// relPath or one of its parents. A submodule that is not pruned is
+// downloaded, its files are filtered as those of any other directory.
+func pruned(filter *pathfilter.Filter, relPath string) bool {
+	for dir := relPath; dir != "."; dir = path.Dir(dir) {
+		if filter.Prune(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadSubmodules downloads each submodule into its output directory
+// with the method of opts. URLs are read from the parent's .gitmodules,
+// submodules of the submodules follow as opts.RecurseSubmodules is kept.
+func downloadSubmodules(opts model.DownloadOptions, links []gitlink, gitmodules []byte) error {
+	urls := parseGitmodules(gitmodules)
+	for _, link := range links {
+		rawURL, ok := urls[link.path]
+		if !ok {
+			return &errors.AppError{
+				Err:     errors.ErrInvalidURL,
+				Message: fmt.Sprintf("Submodule '%s' has no URL in %s", link.path, gitmodulesFile),
+				Hint:    "Download without --recurse-submodules to leave it out",
+			}
+		}
+		repoURL, err := submoduleURL(opts.RepoURL, rawURL)
+		if err != nil {
+			return err
+		}
+
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Downloading submodule %s from %s (commit %s)...\n", link.path, repoURL, link.commit)
+		}
+
+		child := opts
+		child.RepoURL = repoURL
+		child.Ref = link.commit
+		child.Branch = ""
+		child.Subdir = ""
+		child.OutputDir = link.outputDir
+		child.Paths = nil
+		child.Filter = link.filter
+		// Credentials and API endpoints only carry over to the same host.
+		if urlHost(repoURL) != urlHost(opts.RepoURL) {
+			child.Provider = gitutil.DetectProvider(repoURL)
+			child.APIURL = ""
+			child.Token = ""
+		}
+
+		dl, err := GetDownloader(child)
+		if err != nil {
+			return err
+		}
+		if err := dl.Download(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseGitmodules returns the URL of each submodule path in the content of
+// a .gitmodules file.
+func parseGitmodules(content []byte) map[string]string {
+	type entry struct{ path, url string }
+	var entries []*entry
+	var current *entry
+
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "["):
+			current = nil
+			section := strings.TrimSpace(strings.Trim(line, "[]"))
+			if name, _, _ := strings.Cut(section, " "); strings.EqualFold(name, "submodule") {
+				current = &entry{}
+				entries = append(entries, current)
+			}
+		case current != nil:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "path":
+				current.path = strings.Trim(value, "/")
+			case "url":
+				current.url = value
+			}
+		}
+	}
+
+	urls := make(map[string]string)
+	for _, e := range entries {
+		if e.path != "" && e.url != "" {
+			urls[e.path] = e.url
+		}
+	}
+	return urls
+}
+
+// submoduleURL resolves rawURL, which may be relative as in "../lib.git",
+// against the URL of the parent repository. Only remote transports are
+// accepted, a repository must not point git at local paths or helpers.
+func submoduleURL(parentURL, rawURL string) (string, error) {
+	resolved := rawURL
+	if strings.HasPrefix(rawURL, "./") || strings.HasPrefix(rawURL, "../") {
+		base := strings.TrimSuffix(parentURL, "/")
+		rest := rawURL
+		for {
+			if after, ok := strings.CutPrefix(rest, "./"); ok {
+				rest = after
+			} else if after, ok := strings.CutPrefix(rest, "../"); ok {
+				rest = after
+				cut := strings.LastIndexAny(base, "/:")
+				if cut < 0 || strings.HasSuffix(base, ":") {
+					return "", invalidSubmoduleURL(rawURL)
+				}
+				if base[cut] == ':' {
+					// "host:repo" of the scp-like syntax.
+					cut++
+				}
+				base = base[:cut]
+			} else {
+				break
+			}
+		}
+		resolved = base + "/" + rest
+		if strings.HasSuffix(base, ":") {
+			resolved = base + rest
+		}
+	}
+
+	if strings.HasPrefix(resolved, "-") || strings.Contains(resolved, "::") {
+		return "", invalidSubmoduleURL(rawURL)
+	}
+	if !strings.Contains(resolved, "://") && gitutil.IsSCPLikeURL(resolved) {
+		return resolved, nil
+	}
+	parsed, err := url.Parse(resolved)
+	if err != nil {
+		return "", invalidSubmoduleURL(rawURL)
+	}
+	switch parsed.Scheme {
+	case "https", "http", "ssh", "git":
+		return resolved, nil
+	case "":
+		// "host/owner/repo", as accepted for the parent.
+		if strings.Contains(resolved, "/") && !strings.HasPrefix(resolved, "/") {
+			return resolved, nil
+		}
+	}
+	return "", invalidSubmoduleURL(rawURL)
+}
+
+func invalidSubmoduleURL(rawURL string) error {
+	return &errors.AppError{
+		Err:     errors.ErrInvalidURL,
+		Message: fmt.Sprintf("Unsupported submodule URL '%s'", rawURL),
+		Hint:    "Only http(s), ssh and git URLs are followed; download without --recurse-submodules to leave it out",
+	}
+}
+
+// urlHost returns the lower-cased host of a repository URL.
+func urlHost(repoURL string) string {
+	if !strings.Contains(repoURL, "://") && gitutil.IsSCPLikeURL(repoURL) {
+		host, _, _ := strings.Cut(repoURL, ":")
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		return strings.ToLower(host)
+	}
+	if !strings.Contains(repoURL, "://") {
+		host, _, _ := strings.Cut(repoURL, "/")
+		return strings.ToLower(host)
+	}
+	parsed, err := url.Parse(repoURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// findGitlinks lists the submodules below target that filter keeps.
+func findGitlinks(store *gitproto.ObjectStore, rootTree string, target model.PathSpec, filter *pathfilter.Filter) ([]gitlink, error) {
+	entry, err := store.LookupPath(rootTree, strings.Trim(target.Subdir, "/"))
+	if err != nil || entry.Mode != gitproto.ModeTree {
+		return nil, nil
+	}
+
+	var links []gitlink
+	err = store.WalkTree(entry.Hash, func(relPath string, child gitproto.TreeEntry) error {
+		switch child.Mode {
+		case gitproto.ModeTree:
+			if filter.Prune(relPath) {
+				return fs.SkipDir
+			}
+		case gitproto.ModeGitlink:
+			if filter.Prune(relPath) {
+				return nil
+			}
+			link, err := newGitlink(target, relPath, child.Hash, filter)
+			if err != nil {
+				return err
+			}
+			links = append(links, link)
+		}
+		return nil
+	})
+	return links, err
+}
+
+// listGitlinks lists the submodules below target in the fetched commit that
+// filter keeps.
+func (s *sparseCheckoutDownloader) listGitlinks(ctx context.Context, dir string, target model.PathSpec, filter *pathfilter.Filter) ([]gitlink, error) {
+	prefix := strings.Trim(target.Subdir, "/")
+	args := []string{"ls-tree", "-r", "-z", "FETCH_HEAD"}
+	if prefix != "" {
+		args = append(args, "--", prefix+"/")
+	}
+	output, err := gitutil.RunGitCommand(ctx, dir, args...)
+	if err != nil {
+		return nil, errors.ParseGitError(err, "failed to read the repository tree")
+	}
+
+	// 160000 commit <hash>	<path>
+	var links []gitlink
+	for _, line := range strings.Split(output, "\x00") {
+		info, repoPath, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) < 3 || fields[0] != gitproto.ModeGitlink {
+			continue
+		}
+		relPath := repoPath
+		if prefix != "" {
+			relPath = strings.TrimPrefix(repoPath, prefix+"/")
+		}
+		if pruned(filter, relPath) {
+			continue
+		}
+		link, err := newGitlink(target, relPath, fields[2], filter)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// downloadSubmodules reads the commit's .gitmodules and downloads links.
+func (s *sparseCheckoutDownloader) downloadSubmodules(ctx context.Context, dir string, links []gitlink) error {
+	var gitmodules []byte
+	if _, objectType, err := s.treeEntry(ctx, dir, gitmodulesFile); err != nil {
+		return err
+	} else if objectType == "blob" {
+		content, err := gitutil.RunGitCommand(ctx, dir, "cat-file", "blob", "FETCH_HEAD:"+gitmodulesFile)
+		if err != nil {
+			return errors.ParseGitError(err, "failed to read "+gitmodulesFile)
+		}
+		gitmodules = []byte(content)
+	}
+	return downloadSubmodules(s.opts, links, gitmodules)
+}
+
+// readGitmodulesOverHTTP returns the content of the commit's .gitmodules,
+// nil when it has none.
+func (s *sparseCheckoutDownloader) readGitmodulesOverHTTP(ctx context.Context, client *gitproto.Client, store *gitproto.ObjectStore, rootTree string) ([]byte, error) {
+	entry, err := store.LookupPath(rootTree, gitmodulesFile)
+	if err != nil || entry.Mode != gitproto.ModeFile {
+		return nil, nil
+	}
+	if err := s.fetchMissingBlobs(ctx, client, store, []blobFile{{path: gitmodulesFile, hash: entry.Hash}}); err != nil {
+		return nil, err
+	}
+	obj, ok := store.Get(entry.Hash)
+	if !ok {
+		return nil, fmt.Errorf("blob for %s missing from fetched pack", gitmodulesFile)
+	}
+	return obj.Data, nil
+}
+
+// gitmodulesSource is implemented by the downloaders that cannot see
+// submodules. With RecurseSubmodules set they keep the .gitmodules of the
+// downloaded commit, gitmodules returns nil when it has none.
+type gitmodulesSource interface {
+	gitmodules() []byte
+}
+
+// readGitmodules reads the .gitmodules opened by open, nil when the
+// repository has none. The repository itself was found, so a not found
+// response can only concern the file.
+func readGitmodules(open func(ctx context.Context) (io.ReadCloser, error)) ([]byte, error) {
+	body, err := open(context.Background())
+	if err != nil {
+		var appErr *errors.AppError
+		if stderrors.As(err, &appErr) && appErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer body.Close()
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", gitmodulesFile, err)
+	}
+	return content, nil
+}
+
+// declaresSubmodules reports whether gitmodules names a submodule below one
+// of the targets.
+func declaresSubmodules(gitmodules []byte, targets []model.PathSpec) bool {
+	for submodulePath := range parseGitmodules(gitmodules) {
+		for _, target := range targets {
+			subdir := strings.Trim(target.Subdir, "/")
+			if subdir == "" || strings.HasPrefix(submodulePath, subdir+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// submoduleDownloader adds submodules to the downloaders that cannot see
+// them: the provider APIs and archives leave them out. When the commit's
+// .gitmodules declares submodules inside the folders, its trees are fetched
+// over git's HTTP protocol to find them.
+type submoduleDownloader struct {
+	Downloader
+	opts model.DownloadOptions
+}
+
+func (d *submoduleDownloader) Download() error {
+	if err := d.Downloader.Download(); err != nil {
+		return err
+	}
+	if source, ok := d.Downloader.(gitmodulesSource); ok && !declaresSubmodules(source.gitmodules(), d.opts.Targets()) {
+		return nil
+	}
+
+	// Progress of the lookup itself is not reported.
+	finder := &sparseCheckoutDownloader{opts: d.opts}
+	finder.opts.Quiet = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.opts.GitTimeout())
+	defer cancel()
+
+	repoURL := gitutil.AuthenticatedURL(d.opts.RepoURL, d.opts.Token, d.opts.Provider)
+	if !strings.HasPrefix(repoURL, "https://") && !strings.HasPrefix(repoURL, "http://") {
+		return &errors.AppError{
+			Err:     errors.ErrInvalidURL,
+			Message: "Submodules can only be looked up over http(s) with this method",
+			Hint:    "Use the https URL of the repository or --method sparse",
+		}
+	}
+
+	client := gitproto.NewClient(repoURL)
+	if err := client.Connect(ctx); err != nil {
+		return transportError(err)
+	}
+	commit, err := finder.resolveCommitOverHTTP(ctx, client)
+	if err != nil {
+		return transportError(err)
+	}
+
+	filter := ""
+	if client.SupportsFetchFeature("filter") {
+		filter = "blob:none"
+	}
+	store, err := client.Fetch(ctx, gitproto.FetchRequest{Wants: []string{commit}, Depth: 1, Filter: filter})
+	if err != nil {
+		return transportError(err)
+	}
+	commitObj, ok := store.Get(commit)
+	if !ok {
+		return fmt.Errorf("commit %s missing from fetched pack", commit)
+	}
+	rootTree, err := gitproto.CommitTree(commitObj)
+	if err != nil {
+		return err
+	}
+
+	var links []gitlink
+	for _, target := range d.opts.Targets() {
+		targetFilter, err := finder.upstreamFilterOverHTTP(ctx, client, store, rootTree, target)
+		if err != nil {
+			return err
+		}
+		targetLinks, err := findGitlinks(store, rootTree, target, targetFilter)
+		if err != nil {
+			return err
+		}
+		links = append(links, targetLinks...)
+	}
+	if len(links) == 0 {
+		return nil
+	}
+
+	gitmodules, err := finder.readGitmodulesOverHTTP(ctx, client, store, rootTree)
+	if err != nil {
+		return err
+	}
+	return downloadSubmodules(d.opts, links, gitmodules)
+}
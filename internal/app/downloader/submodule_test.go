@@ -0,0 +1,39 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+)
+
+func TestDeclaresSubmodules(t *testing.T) {
+	const gitmodules = `[submodule "lib"]
+	path = src/vendor/lib
+	url = https://example.com/lib.git
+[submodule "no url"]
+	path = docs/theme
+`
+	tests := []struct {
+		gitmodules string
+		subdirs    []string
+		want       bool
+	}{
+		{gitmodules, []string{"src"}, true},
+		{gitmodules, []string{"/src/vendor/"}, true},
+		{gitmodules, []string{""}, true},
+		{gitmodules, []string{"docs", "src/vendor"}, true},
+		{gitmodules, []string{"docs"}, false},
+		{gitmodules, []string{"sr"}, false},
+		{gitmodules, []string{"src/vendor/lib"}, false},
+		{"", []string{""}, false},
+	}
+	for _, tt := range tests {
+		var targets []model.PathSpec
+		for _, subdir := range tt.subdirs {
+			targets = append(targets, model.PathSpec{Subdir: subdir, OutputDir: "out"})
+		}
+		if got := declaresSubmodules([]byte(tt.gitmodules), targets); got != tt.want {
+			t.Errorf("declaresSubmodules for %q = %v, want %v", tt.subdirs, got, tt.want)
+		}
+	}
+}
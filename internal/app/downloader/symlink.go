@@ -0,0 +1,40 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+)
+
+// maxLinkTarget bounds how much of a link blob is read, git stores the
+// target path as the blob content.
+const maxLinkTarget = 4096
+
+// writeSymlink creates the link relPath of the downloaded folder at
+// targetPath. content holds the link target, which must stay inside the
+// folder.
+func writeSymlink(sink output.Sink, relPath, targetPath string, content io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(content, maxLinkTarget))
+	if err != nil {
+		return fmt.Errorf("failed to read link %s: %w", relPath, err)
+	}
+	target := string(data)
+	if err := safepath.CheckLink(relPath, target); err != nil {
+		return err
+	}
+	return sink.Symlink(targetPath, target)
+}
+
+// downloadSymlink fetches the blob of the link relPath with open and creates
+// the link at targetPath.
+func downloadSymlink(ctx context.Context, sink output.Sink, relPath, targetPath string, open func(ctx context.Context) (io.ReadCloser, error)) error {
+	body, err := open(ctx)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return writeSymlink(sink, relPath, targetPath, body)
+}
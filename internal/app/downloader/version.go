@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/gitproto"
+	"github.com/dagimg-dot/gitsnip/internal/app/gitutil"
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	"github.com/dagimg-dot/gitsnip/internal/app/semver"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+// ResolveVersionConstraint returns the tag with the highest semantic version
+// that satisfies the constraint in opts.Ref, e.g. "^1.4" or "~2.0.x". An
+// x-range such as 2.x that is also the name of a branch or tag resolves to
+// that ref instead.
+func ResolveVersionConstraint(opts model.DownloadOptions) (string, error) {
+	constraint, err := semver.ParseConstraint(opts.Ref)
+	if err != nil {
+		return "", &errors.AppError{
+			Err:     errors.ErrInvalidRef,
+			Message: fmt.Sprintf("Invalid version constraint '%s'", opts.Ref),
+			Hint:    "Use a constraint such as ^1.4, ~2.0.x, 1.x or '>=1.2 <2'",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.GitTimeout())
+	defer cancel()
+
+	xRange := semver.IsXRange(opts.Ref)
+	prefixes := []string{"refs/tags/"}
+	if xRange {
+		prefixes = append(prefixes, "refs/heads/")
+	}
+	refs, err := listRemoteRefs(ctx, gitutil.AuthenticatedURL(opts.RepoURL, opts.Token, opts.Provider), prefixes...)
+	if err != nil {
+		return "", err
+	}
+
+	var tags []string
+	for _, name := range refs {
+		if xRange && (name == "refs/heads/"+opts.Ref || name == "refs/tags/"+opts.Ref) {
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Using %s, write the constraint as '~%s' to match tags instead\n",
+					name, strings.TrimLeft(opts.Ref, "vV"))
+			}
+			return opts.Ref, nil
+		}
+		if tag, ok := strings.CutPrefix(name, "refs/tags/"); ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	tag, ok := constraint.Highest(tags)
+	if !ok {
+		return "", &errors.AppError{
+			Err:     errors.ErrPathNotFound,
+			Message: fmt.Sprintf("No tag matches the version constraint '%s'", opts.Ref),
+			Hint:    "Check the repository's tags, prereleases only match constraints that name one",
+		}
+	}
+
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Constraint %s matched tag %s\n", opts.Ref, tag)
+	}
+	return tag, nil
+}
+
+// listRemoteRefs returns the names of the remote refs below prefixes, using
+// git when it is installed and the built-in transport otherwise.
+func listRemoteRefs(ctx context.Context, repoURL string, prefixes ...string) ([]string, error) {
+	var names []string
+
+	if gitutil.IsGitInstalled() {
+		output, err := gitutil.RunGitCommand(ctx, "", "ls-remote", "--refs", repoURL)
+		if err != nil {
+			return nil, errors.ParseGitError(err, "failed to list remote refs")
+		}
+		for _, line := range strings.Split(output, "\n") {
+			if _, name, ok := strings.Cut(line, "\t"); ok && hasAnyPrefix(name, prefixes) {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+
+	client := gitproto.NewClient(repoURL)
+	if err := client.Connect(ctx); err != nil {
+		return nil, transportError(err)
+	}
+	refs, err := client.LsRefs(ctx, prefixes...)
+	if err != nil {
+		return nil, transportError(err)
+	}
+	for _, ref := range refs {
+		names = append(names, ref.Name)
+	}
+	return names, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
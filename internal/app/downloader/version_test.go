@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+)
+
+// newTagRepo creates a local repository with the given branches and tags on
+// a single commit.
+func newTagRepo(t *testing.T, branches, tags []string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q", "-b", "main")
+	run("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init")
+	for _, branch := range branches {
+		run("branch", branch)
+	}
+	for _, tag := range tags {
+		run("tag", tag)
+	}
+	return "file://" + dir
+}
+
+func TestResolveVersionConstraint(t *testing.T) {
+	tags := []string{"v1.9.0", "v2.0.0", "v2.3.1", "v3.0.0-rc.1", "1.x.x"}
+	tests := []struct {
+		ref      string
+		branches []string
+		want     string
+	}{
+		{ref: "^2", want: "v2.3.1"},
+		{ref: "2.x", want: "v2.3.1"},
+		// An x-range naming a branch or tag resolves to that ref.
+		{ref: "2.x", branches: []string{"2.x"}, want: "2.x"},
+		{ref: "1.x.x", want: "1.x.x"},
+		{ref: "~2.x", branches: []string{"2.x"}, want: "v2.3.1"},
+		{ref: "2.*", branches: []string{"2.x"}, want: "v2.3.1"},
+	}
+	for _, tt := range tests {
+		repoURL := newTagRepo(t, tt.branches, tags)
+		got, err := ResolveVersionConstraint(model.DownloadOptions{RepoURL: repoURL, Ref: tt.ref, Quiet: true})
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveVersionConstraint(%q) with branches %v = %q, %v, want %q", tt.ref, tt.branches, got, err, tt.want)
+		}
+	}
+
+	repoURL := newTagRepo(t, nil, tags)
+	if _, err := ResolveVersionConstraint(model.DownloadOptions{RepoURL: repoURL, Ref: "^4", Quiet: true}); err == nil {
+		t.Error("ResolveVersionConstraint(^4) succeeded without a matching tag")
+	}
+}
@@ -0,0 +1,425 @@
+// Package gitproto implements the client side of git's smart HTTP transport
+// (protocol version 2), enough to list refs and fetch a shallow, filtered
+// packfile without a git binary.
+package gitproto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	userAgent      = "GitSnip/1.0"
+	requestTimeout = 5 * time.Minute
+
+	uploadPackService = "git-upload-pack"
+)
+
+var (
+	ErrRefNotFound    = errors.New("reference not found")
+	ErrAmbiguousRef   = errors.New("short commit hash is ambiguous")
+	ErrPathNotFound   = errors.New("path not found in tree")
+	commitHashPattern = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+)
+
+// StatusError is returned when the server answers with an unexpected HTTP
+// status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d: %s", e.StatusCode, e.Body)
+}
+
+// Ref is a single entry of an ls-refs response.
+type Ref struct {
+	Name   string
+	Hash   string
+	Target string // symref target, e.g. "refs/heads/main" for HEAD
+	Peeled string // commit a tag object points to
+}
+
+// Client talks to a single repository. Credentials embedded in the URL are
+// sent as HTTP basic auth.
+type Client struct {
+	repoURL      string
+	http         *http.Client
+	capabilities map[string]string
+}
+
+func NewClient(repoURL string) *Client {
+	return &Client{
+		repoURL: strings.TrimSuffix(repoURL, "/"),
+		http:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Connect performs capability advertisement and verifies that the server
+// speaks protocol version 2.
+func (c *Client) Connect(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		c.repoURL+"/info/refs?service="+uploadPackService, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Git-Protocol", "version=2")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	capabilities := make(map[string]string)
+	version2 := false
+
+	pkts := newPktReader(resp.Body)
+	for {
+		kind, line, err := pkts.nextLine()
+		if err != nil {
+			return fmt.Errorf("failed to read capability advertisement: %w", err)
+		}
+		if kind == pktFlush {
+			if version2 {
+				break
+			}
+			// The "# service=" preamble is terminated by its own flush.
+			continue
+		}
+		if kind != pktData {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "# service="):
+		case line == "version 2":
+			version2 = true
+		case version2:
+			key, value, _ := strings.Cut(line, "=")
+			capabilities[key] = value
+		default:
+			return fmt.Errorf("server does not support git protocol version 2")
+		}
+	}
+
+	c.capabilities = capabilities
+	return nil
+}
+
+// SupportsFetchFeature reports whether the fetch command advertises feature,
+// e.g. "filter" or "shallow".
+func (c *Client) SupportsFetchFeature(feature string) bool {
+	for _, f := range strings.Fields(c.capabilities["fetch"]) {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// LsRefs lists the refs matching any of the given prefixes, including
+// symref targets and peeled tags.
+func (c *Client) LsRefs(ctx context.Context, prefixes ...string) ([]Ref, error) {
+	var w pktWriter
+	w.line("command=ls-refs")
+	w.line("agent=%s", userAgent)
+	w.delim()
+	w.line("peel")
+	w.line("symrefs")
+	for _, prefix := range prefixes {
+		w.line("ref-prefix %s", prefix)
+	}
+	w.flush()
+
+	resp, err := c.command(ctx, w.bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var refs []Ref
+	pkts := newPktReader(resp.Body)
+	for {
+		kind, line, err := pkts.nextLine()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ls-refs response: %w", err)
+		}
+		if kind == pktFlush {
+			break
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		ref := Ref{Hash: fields[0], Name: fields[1]}
+		for _, attr := range fields[2:] {
+			if target, ok := strings.CutPrefix(attr, "symref-target:"); ok {
+				ref.Target = target
+			} else if peeled, ok := strings.CutPrefix(attr, "peeled:"); ok {
+				ref.Peeled = peeled
+			}
+		}
+		refs = append(refs, ref)
+	}
+
+	return refs, nil
+}
+
+// ResolveRef returns the commit that name points to. name may be a branch,
+// a tag, a full ref name or empty for the remote's HEAD.
+func (c *Client) ResolveRef(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		name = "HEAD"
+	}
+
+	candidates := []string{name}
+	if !strings.HasPrefix(name, "refs/") && name != "HEAD" {
+		candidates = []string{"refs/heads/" + name, "refs/tags/" + name}
+	}
+
+	return c.resolveCandidates(ctx, candidates)
+}
+
+// ResolveCommit returns the full hash of the commit ref names. ref may be a
+// tag, a branch, any full ref name such as refs/pull/1/head or a full or
+// abbreviated commit hash. Names are looked up in the order git rev-parse
+// uses, abbreviated hashes are expanded with ExpandCommit.
+func (c *Client) ResolveCommit(ctx context.Context, ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if len(ref) == 40 && commitHashPattern.MatchString(ref) {
+		return ref, nil
+	}
+
+	candidates := []string{ref}
+	if !strings.HasPrefix(ref, "refs/") {
+		candidates = []string{"refs/" + ref, "refs/tags/" + ref, "refs/heads/" + ref}
+	}
+
+	hash, err := c.resolveCandidates(ctx, candidates)
+	if err == ErrRefNotFound && commitHashPattern.MatchString(strings.ToLower(ref)) {
+		return c.ExpandCommit(ctx, strings.ToLower(ref))
+	}
+	return hash, err
+}
+
+// ExpandCommit expands an abbreviated commit hash. Ref tips are checked
+// first; otherwise the commit history is fetched, without trees and blobs
+// when the server supports filters, and searched for the prefix.
+func (c *Client) ExpandCommit(ctx context.Context, prefix string) (string, error) {
+	refs, err := c.LsRefs(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	tips := make(map[string]bool)
+	for _, ref := range refs {
+		hash := ref.Hash
+		if ref.Peeled != "" {
+			hash = ref.Peeled
+		}
+		tips[hash] = true
+	}
+
+	var matches []string
+	for hash := range tips {
+		if strings.HasPrefix(hash, prefix) {
+			matches = append(matches, hash)
+		}
+	}
+	if len(matches) == 0 && len(tips) > 0 {
+		fetch := FetchRequest{}
+		for hash := range tips {
+			fetch.Wants = append(fetch.Wants, hash)
+		}
+		if c.SupportsFetchFeature("filter") {
+			fetch.Filter = "tree:0"
+		}
+
+		store, err := c.Fetch(ctx, fetch)
+		if err != nil {
+			return "", err
+		}
+		matches = store.Find(prefix, ObjectCommit)
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", ErrRefNotFound
+	case 1:
+		return matches[0], nil
+	default:
+		return "", ErrAmbiguousRef
+	}
+}
+
+// resolveCandidates returns the commit of the first candidate ref that
+// exists on the remote, peeling annotated tags.
+func (c *Client) resolveCandidates(ctx context.Context, candidates []string) (string, error) {
+	refs, err := c.LsRefs(ctx, candidates...)
+	if err != nil {
+		return "", err
+	}
+
+	for _, candidate := range candidates {
+		for _, ref := range refs {
+			if ref.Name != candidate {
+				continue
+			}
+			if ref.Peeled != "" {
+				return ref.Peeled, nil
+			}
+			return ref.Hash, nil
+		}
+	}
+
+	return "", ErrRefNotFound
+}
+
+// DefaultBranch returns the branch the remote's HEAD points to.
+func (c *Client) DefaultBranch(ctx context.Context) (string, error) {
+	refs, err := c.LsRefs(ctx, "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	for _, ref := range refs {
+		if ref.Name == "HEAD" && ref.Target != "" {
+			return strings.TrimPrefix(ref.Target, "refs/heads/"), nil
+		}
+	}
+	return "", ErrRefNotFound
+}
+
+// FetchRequest describes a fetch command. Depth and Filter are optional.
+type FetchRequest struct {
+	Wants  []string
+	Depth  int
+	Filter string
+}
+
+// Fetch runs the fetch command and returns the objects of the resulting
+// packfile.
+func (c *Client) Fetch(ctx context.Context, fetch FetchRequest) (*ObjectStore, error) {
+	var w pktWriter
+	w.line("command=fetch")
+	w.line("agent=%s", userAgent)
+	w.delim()
+	w.line("no-progress")
+	w.line("ofs-delta")
+	if fetch.Depth > 0 {
+		w.line("deepen %d", fetch.Depth)
+	}
+	if fetch.Filter != "" {
+		w.line("filter %s", fetch.Filter)
+	}
+	for _, want := range fetch.Wants {
+		w.line("want %s", want)
+	}
+	w.line("done")
+	w.flush()
+
+	resp, err := c.command(ctx, w.bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	pack, err := readFetchResponse(newPktReader(resp.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePackfile(pack)
+}
+
+// readFetchResponse skips the informational sections of a fetch response and
+// demultiplexes the side-band encoded packfile section.
+func readFetchResponse(pkts *pktReader) ([]byte, error) {
+	for {
+		kind, line, err := pkts.nextLine()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fetch response: %w", err)
+		}
+		if kind != pktData {
+			continue
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("server error: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		if line == "packfile" {
+			break
+		}
+		// shallow-info, wanted-refs and acknowledgments end with a delimiter.
+		for kind == pktData {
+			if kind, _, err = pkts.next(); err != nil {
+				return nil, fmt.Errorf("failed to read fetch response: %w", err)
+			}
+		}
+	}
+
+	var pack bytes.Buffer
+	for {
+		kind, payload, err := pkts.next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read packfile: %w", err)
+		}
+		if kind == pktFlush {
+			break
+		}
+		if kind != pktData || len(payload) == 0 {
+			continue
+		}
+
+		switch payload[0] {
+		case 1:
+			pack.Write(payload[1:])
+		case 2:
+			// progress messages
+		case 3:
+			return nil, fmt.Errorf("server error: %s", strings.TrimSpace(string(payload[1:])))
+		}
+	}
+
+	return pack.Bytes(), nil
+}
+
+func (c *Client) command(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		c.repoURL+"/"+uploadPackService, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Git-Protocol", "version=2")
+	req.Header.Set("Content-Type", "application/x-"+uploadPackService+"-request")
+	req.Header.Set("Accept", "application/x-"+uploadPackService+"-result")
+
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+
+	return resp, nil
+}
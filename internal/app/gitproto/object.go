@@ -0,0 +1,149 @@
+package gitproto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+const (
+	ModeTree       = "40000"
+	ModeFile       = "100644"
+	ModeExecutable = "100755"
+	ModeSymlink    = "120000"
+	ModeGitlink    = "160000"
+)
+
+type TreeEntry struct {
+	Mode string
+	Name string
+	Hash string
+}
+
+func (e TreeEntry) IsTree() bool {
+	return e.Mode == ModeTree
+}
+
+// CommitTree returns the root tree of a commit object.
+func CommitTree(obj *Object) (string, error) {
+	if obj.Type != ObjectCommit {
+		return "", fmt.Errorf("object is a %s, not a commit", obj.Type)
+	}
+
+	for _, line := range strings.Split(string(obj.Data), "\n") {
+		if tree, ok := strings.CutPrefix(line, "tree "); ok {
+			return tree, nil
+		}
+		if line == "" {
+			break
+		}
+	}
+	return "", fmt.Errorf("commit has no tree")
+}
+
+// ParseTree decodes the entries of a tree object.
+func ParseTree(obj *Object) ([]TreeEntry, error) {
+	if obj.Type != ObjectTree {
+		return nil, fmt.Errorf("object is a %s, not a tree", obj.Type)
+	}
+
+	var entries []TreeEntry
+	data := obj.Data
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			return nil, fmt.Errorf("malformed tree entry")
+		}
+		nul := bytes.IndexByte(data[space:], 0)
+		if nul < 0 || space+nul+21 > len(data) {
+			return nil, fmt.Errorf("malformed tree entry")
+		}
+		nul += space
+
+		entries = append(entries, TreeEntry{
+			Mode: string(data[:space]),
+			Name: string(data[space+1 : nul]),
+			Hash: hex.EncodeToString(data[nul+1 : nul+21]),
+		})
+		data = data[nul+21:]
+	}
+
+	return entries, nil
+}
+
+// LookupPath follows a slash separated path from the tree rootTree and
+// returns the entry it names. The empty path names the root tree itself.
+func (s *ObjectStore) LookupPath(rootTree, path string) (TreeEntry, error) {
+	entry := TreeEntry{Mode: ModeTree, Hash: rootTree}
+
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !entry.IsTree() {
+			return TreeEntry{}, fmt.Errorf("%s is not a directory", entry.Name)
+		}
+
+		obj, ok := s.Get(entry.Hash)
+		if !ok {
+			return TreeEntry{}, fmt.Errorf("tree %s missing from pack", entry.Hash)
+		}
+		children, err := ParseTree(obj)
+		if err != nil {
+			return TreeEntry{}, err
+		}
+
+		found := false
+		for _, child := range children {
+			if child.Name == name {
+				entry, found = child, true
+				break
+			}
+		}
+		if !found {
+			return TreeEntry{}, ErrPathNotFound
+		}
+	}
+
+	return entry, nil
+}
+
+// WalkTree calls fn for every entry below the tree with the given hash.
+// Paths passed to fn are slash separated and relative to that tree. fn may
+// return fs.SkipDir for a tree entry to skip its contents.
+func (s *ObjectStore) WalkTree(tree string, fn func(path string, entry TreeEntry) error) error {
+	return s.walkTree(tree, "", fn)
+}
+
+func (s *ObjectStore) walkTree(tree, prefix string, fn func(path string, entry TreeEntry) error) error {
+	obj, ok := s.Get(tree)
+	if !ok {
+		return fmt.Errorf("tree %s missing from pack", tree)
+	}
+	entries, err := ParseTree(obj)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entryPath := entry.Name
+		if prefix != "" {
+			entryPath = prefix + "/" + entry.Name
+		}
+		err := fn(entryPath, entry)
+		if err == fs.SkipDir && entry.IsTree() {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsTree() {
+			if err := s.walkTree(entry.Hash, entryPath, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package gitproto
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type ObjectType int
+
+const (
+	ObjectCommit   ObjectType = 1
+	ObjectTree     ObjectType = 2
+	ObjectBlob     ObjectType = 3
+	ObjectTag      ObjectType = 4
+	objectOfsDelta ObjectType = 6
+	objectRefDelta ObjectType = 7
+)
+
+func (t ObjectType) String() string {
+	switch t {
+	case ObjectCommit:
+		return "commit"
+	case ObjectTree:
+		return "tree"
+	case ObjectBlob:
+		return "blob"
+	case ObjectTag:
+		return "tag"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+type Object struct {
+	Type ObjectType
+	Data []byte
+}
+
+// ObjectStore holds fetched objects keyed by their hex object id.
+type ObjectStore struct {
+	objects map[string]*Object
+}
+
+func NewObjectStore() *ObjectStore {
+	return &ObjectStore{objects: make(map[string]*Object)}
+}
+
+func (s *ObjectStore) Get(hash string) (*Object, bool) {
+	obj, ok := s.objects[hash]
+	return obj, ok
+}
+
+// Find returns the hashes of all objects of type typ whose hash starts with
+// prefix.
+func (s *ObjectStore) Find(prefix string, typ ObjectType) []string {
+	var hashes []string
+	for hash, obj := range s.objects {
+		if obj.Type == typ && strings.HasPrefix(hash, prefix) {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+// Merge adds all objects of other to s.
+func (s *ObjectStore) Merge(other *ObjectStore) {
+	for hash, obj := range other.objects {
+		s.objects[hash] = obj
+	}
+}
+
+func (s *ObjectStore) add(obj *Object) string {
+	hash := hashObject(obj)
+	s.objects[hash] = obj
+	return hash
+}
+
+func hashObject(obj *Object) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", obj.Type, len(obj.Data))
+	h.Write(obj.Data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+const maxDeltaDepth = 10000
+
+var errMissingBase = errors.New("delta base not available yet")
+
+// packEntry is an object as stored in the pack, before deltas are resolved.
+type packEntry struct {
+	offset  int64
+	typ     ObjectType
+	data    []byte
+	baseOfs int64  // for offset deltas
+	baseRef string // for reference deltas
+}
+
+// parsePackfile decodes a version 2 packfile held in memory and resolves all
+// deltas against objects from the same pack.
+func parsePackfile(pack []byte) (*ObjectStore, error) {
+	store := NewObjectStore()
+	if len(pack) == 0 {
+		return store, nil
+	}
+
+	if len(pack) < 12 || string(pack[:4]) != "PACK" {
+		return nil, fmt.Errorf("invalid packfile header")
+	}
+	if version := binary.BigEndian.Uint32(pack[4:8]); version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported packfile version %d", version)
+	}
+	count := binary.BigEndian.Uint32(pack[8:12])
+
+	r := bytes.NewReader(pack)
+	r.Seek(12, io.SeekStart)
+
+	entries := make([]*packEntry, 0, count)
+	byOffset := make(map[int64]*packEntry, count)
+
+	for i := uint32(0); i < count; i++ {
+		entry, err := readPackEntry(r, int64(len(pack))-int64(r.Len()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read packfile object %d: %w", i, err)
+		}
+		entries = append(entries, entry)
+		byOffset[entry.offset] = entry
+	}
+
+	resolved := make(map[int64]*Object, count)
+	var resolve func(entry *packEntry, depth int) (*Object, error)
+	resolve = func(entry *packEntry, depth int) (*Object, error) {
+		if obj, ok := resolved[entry.offset]; ok {
+			return obj, nil
+		}
+		if depth > maxDeltaDepth {
+			return nil, fmt.Errorf("delta chain too deep")
+		}
+
+		var base *Object
+		switch entry.typ {
+		case objectOfsDelta:
+			baseEntry, ok := byOffset[entry.baseOfs]
+			if !ok {
+				return nil, fmt.Errorf("missing delta base at offset %d", entry.baseOfs)
+			}
+			var err error
+			if base, err = resolve(baseEntry, depth+1); err != nil {
+				return nil, err
+			}
+		case objectRefDelta:
+			obj, ok := store.Get(entry.baseRef)
+			if !ok {
+				return nil, errMissingBase
+			}
+			base = obj
+		default:
+			obj := &Object{Type: entry.typ, Data: entry.data}
+			resolved[entry.offset] = obj
+			return obj, nil
+		}
+
+		data, err := applyDelta(base.Data, entry.data)
+		if err != nil {
+			return nil, err
+		}
+		obj := &Object{Type: base.Type, Data: data}
+		resolved[entry.offset] = obj
+		return obj, nil
+	}
+
+	// Reference deltas may point at objects that are themselves deltas, so
+	// keep resolving until every entry is done or no progress is made.
+	pending := entries
+	for len(pending) > 0 {
+		var next []*packEntry
+		for _, entry := range pending {
+			obj, err := resolve(entry, 0)
+			if err == errMissingBase {
+				next = append(next, entry)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			store.add(obj)
+		}
+		if len(next) == len(pending) {
+			return nil, fmt.Errorf("missing delta base %s", next[0].baseRef)
+		}
+		pending = next
+	}
+
+	return store, nil
+}
+
+func readPackEntry(r *bytes.Reader, offset int64) (*packEntry, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &packEntry{offset: offset, typ: ObjectType((c >> 4) & 7)}
+	size := uint64(c & 0x0f)
+	shift := uint(4)
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		size |= uint64(c&0x7f) << shift
+		shift += 7
+	}
+
+	switch entry.typ {
+	case objectOfsDelta:
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		distance := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			distance = ((distance + 1) << 7) | int64(c&0x7f)
+		}
+		entry.baseOfs = offset - distance
+	case objectRefDelta:
+		var base [20]byte
+		if _, err := io.ReadFull(r, base[:]); err != nil {
+			return nil, err
+		}
+		entry.baseRef = hex.EncodeToString(base[:])
+	case ObjectCommit, ObjectTree, ObjectBlob, ObjectTag:
+	default:
+		return nil, fmt.Errorf("unknown object type %d", entry.typ)
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, err
+	}
+	// Drain the stream so the reader is positioned after the checksum.
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return nil, err
+	}
+	if err := zr.Close(); err != nil {
+		return nil, err
+	}
+	entry.data = data
+
+	return entry, nil
+}
+
+// applyDelta reconstructs an object from its base and a git delta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+
+	srcSize, err := readDeltaSize(r)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != uint64(len(base)) {
+		return nil, fmt.Errorf("delta base size mismatch")
+	}
+	dstSize, err := readDeltaSize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, dstSize)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		if op&0x80 != 0 {
+			var offset, size uint64
+			for i := uint(0); i < 4; i++ {
+				if op&(1<<i) != 0 {
+					b, err := r.ReadByte()
+					if err != nil {
+						return nil, err
+					}
+					offset |= uint64(b) << (8 * i)
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if op&(0x10<<i) != 0 {
+					b, err := r.ReadByte()
+					if err != nil {
+						return nil, err
+					}
+					size |= uint64(b) << (8 * i)
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) {
+				return nil, fmt.Errorf("delta copy out of range")
+			}
+			out = append(out, base[offset:offset+size]...)
+		} else if op != 0 {
+			chunk := make([]byte, op)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, err
+			}
+			out = append(out, chunk...)
+		} else {
+			return nil, fmt.Errorf("invalid delta opcode")
+		}
+	}
+
+	if uint64(len(out)) != dstSize {
+		return nil, fmt.Errorf("delta result size mismatch")
+	}
+	return out, nil
+}
+
+func readDeltaSize(r *bytes.Reader) (uint64, error) {
+	var size uint64
+	var shift uint
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		size |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return size, nil
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package gitproto
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// deltaSize encodes a size as in the delta header.
+func deltaSize(n int) []byte {
+	var b []byte
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// copyOp encodes a copy instruction with one byte for offset and size.
+func copyOp(offset, size byte) []byte {
+	return []byte{0x80 | 0x01 | 0x10, offset, size}
+}
+
+// insertOp encodes an insert instruction.
+func insertOp(data string) []byte {
+	return append([]byte{byte(len(data))}, data...)
+}
+
+func delta(srcSize, dstSize int, ops ...[]byte) []byte {
+	d := append(deltaSize(srcSize), deltaSize(dstSize)...)
+	for _, op := range ops {
+		d = append(d, op...)
+	}
+	return d
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world")
+	big := bytes.Repeat([]byte("0123456789abcdef"), 0x10000/16)
+
+	tests := []struct {
+		name  string
+		base  []byte
+		delta []byte
+		want  string
+	}{
+		{
+			name:  "copy and insert",
+			base:  base,
+			delta: delta(11, 11, copyOp(6, 5), insertOp(" "), copyOp(0, 5)),
+			want:  "world hello",
+		},
+		{
+			name:  "insert only",
+			base:  base,
+			delta: delta(11, 3, insertOp("new")),
+			want:  "new",
+		},
+		{
+			name:  "multi byte offset and size",
+			base:  big,
+			delta: delta(len(big), 0x300, []byte{0x80 | 0x01 | 0x02 | 0x10 | 0x20, 0x00, 0x01, 0x00, 0x03}),
+			want:  string(big[0x100:0x400]),
+		},
+		{
+			name:  "size zero copies 64 KiB",
+			base:  big,
+			delta: delta(len(big), len(big), []byte{0x80}),
+			want:  string(big),
+		},
+		{
+			name:  "empty result",
+			base:  base,
+			delta: delta(11, 0),
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		got, err := applyDelta(tt.base, tt.delta)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, truncate(got), truncate([]byte(tt.want)))
+		}
+	}
+}
+
+func TestApplyDeltaErrors(t *testing.T) {
+	base := []byte("hello world")
+	tests := []struct {
+		name  string
+		delta []byte
+	}{
+		{"empty", nil},
+		{"base size mismatch", delta(10, 5, copyOp(0, 5))},
+		{"missing result size", deltaSize(11)},
+		{"copy out of range", delta(11, 5, copyOp(8, 5))},
+		{"reserved opcode", delta(11, 1, []byte{0})},
+		{"truncated insert", delta(11, 5, []byte{5, 'a', 'b'})},
+		{"truncated copy", delta(11, 5, []byte{0x80 | 0x01 | 0x10, 0})},
+		{"result size mismatch", delta(11, 6, copyOp(0, 5))},
+	}
+	for _, tt := range tests {
+		if _, err := applyDelta(base, tt.delta); err == nil {
+			t.Errorf("%s: applyDelta succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func truncate(b []byte) string {
+	if len(b) > 32 {
+		return string(b[:32]) + "..."
+	}
+	return string(b)
+}
+
+// packBuilder writes a version 2 packfile.
+type packBuilder struct {
+	entries [][]byte
+	offset  int
+}
+
+func (p *packBuilder) entry(typ ObjectType, data []byte, extra []byte) int {
+	offset := 12 + p.offset
+
+	size := len(data)
+	hdr := []byte{byte(typ)<<4 | byte(size&0x0f)}
+	for size >>= 4; size > 0; size >>= 7 {
+		hdr[len(hdr)-1] |= 0x80
+		hdr = append(hdr, byte(size&0x7f))
+	}
+	hdr = append(hdr, extra...)
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(data)
+	zw.Close()
+
+	e := append(hdr, z.Bytes()...)
+	p.entries = append(p.entries, e)
+	p.offset += len(e)
+	return offset
+}
+
+func (p *packBuilder) bytes() []byte {
+	pack := []byte("PACK")
+	pack = binary.BigEndian.AppendUint32(pack, 2)
+	pack = binary.BigEndian.AppendUint32(pack, uint32(len(p.entries)))
+	for _, e := range p.entries {
+		pack = append(pack, e...)
+	}
+	sum := sha1.Sum(pack)
+	return append(pack, sum[:]...)
+}
+
+// ofsDistance encodes the distance to the base of an offset delta.
+func ofsDistance(n int) []byte {
+	b := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		n--
+		b = append([]byte{0x80 | byte(n&0x7f)}, b...)
+	}
+	return b
+}
+
+func TestParsePackfile(t *testing.T) {
+	const helloHash = "95d09f2b10159347eece71399a7e2e907ea3df4f"
+	hello, _ := hex.DecodeString(helloHash)
+	long := strings.Repeat("long content ", 200)
+
+	var p packBuilder
+	// The reference delta comes first, its base is only known later.
+	p.entry(objectRefDelta, delta(11, 11, copyOp(6, 5), insertOp(" "), copyOp(0, 5)), hello)
+	base := p.entry(ObjectBlob, []byte("hello world"), nil)
+	first := p.entry(ObjectBlob, []byte(long), nil)
+	chained := p.entry(objectOfsDelta, delta(11, 8, copyOp(0, 5), insertOp(" go")), ofsDistance(12+p.offset-base))
+	p.entry(objectOfsDelta, delta(len(long), 4, copyOp(0, 4)), ofsDistance(12+p.offset-first))
+	p.entry(objectOfsDelta, delta(8, 2, copyOp(6, 2)), ofsDistance(12+p.offset-chained))
+
+	store, err := parsePackfile(p.bytes())
+	if err != nil {
+		t.Fatalf("parsePackfile: %v", err)
+	}
+
+	for _, want := range []string{"hello world", "world hello", long, "hello go", "long", "go"} {
+		hash := hashObject(&Object{Type: ObjectBlob, Data: []byte(want)})
+		obj, ok := store.Get(hash)
+		if !ok {
+			t.Errorf("object %q missing from the store", truncate([]byte(want)))
+			continue
+		}
+		if obj.Type != ObjectBlob || string(obj.Data) != want {
+			t.Errorf("object %s = %s %q, want blob %q", hash, obj.Type, truncate(obj.Data), truncate([]byte(want)))
+		}
+	}
+	if _, ok := store.Get(helloHash); !ok {
+		t.Errorf("blob %s missing, object ids do not match git", helloHash)
+	}
+}
+
+func TestParsePackfileErrors(t *testing.T) {
+	var missing packBuilder
+	missing.entry(objectRefDelta, delta(11, 5, copyOp(0, 5)), make([]byte, 20))
+
+	var badOffset packBuilder
+	badOffset.entry(ObjectBlob, []byte("hello world"), nil)
+	badOffset.entry(objectOfsDelta, delta(11, 5, copyOp(0, 5)), ofsDistance(1))
+
+	version3 := missing.bytes()
+	binary.BigEndian.PutUint32(version3[4:8], 4)
+
+	tests := []struct {
+		name string
+		pack []byte
+	}{
+		{"bad signature", []byte("JUNK\x00\x00\x00\x02\x00\x00\x00\x00")},
+		{"short header", []byte("PACK")},
+		{"unsupported version", version3},
+		{"truncated", missing.bytes()[:14]},
+		{"missing reference base", missing.bytes()},
+		{"missing offset base", badOffset.bytes()},
+	}
+	for _, tt := range tests {
+		if _, err := parsePackfile(tt.pack); err == nil {
+			t.Errorf("%s: parsePackfile succeeded, want an error", tt.name)
+		}
+	}
+
+	store, err := parsePackfile(nil)
+	if err != nil || len(store.objects) != 0 {
+		t.Errorf("parsePackfile(nil) = %d objects, %v, want an empty store", len(store.objects), err)
+	}
+}
@@ -0,0 +1,98 @@
+package gitproto
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const maxPktLen = 65520
+
+// pktKind distinguishes data lines from the special zero length packets.
+type pktKind int
+
+const (
+	pktData pktKind = iota
+	pktFlush
+	pktDelim
+	pktResponseEnd
+)
+
+// pktWriter encodes pkt-lines as described in gitprotocol-common(5).
+type pktWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *pktWriter) line(format string, args ...any) {
+	payload := fmt.Sprintf(format, args...) + "\n"
+	fmt.Fprintf(&w.buf, "%04x%s", len(payload)+4, payload)
+}
+
+func (w *pktWriter) flush() {
+	w.buf.WriteString("0000")
+}
+
+func (w *pktWriter) delim() {
+	w.buf.WriteString("0001")
+}
+
+func (w *pktWriter) bytes() []byte {
+	return w.buf.Bytes()
+}
+
+type pktReader struct {
+	r   *bufio.Reader
+	hdr [4]byte
+}
+
+func newPktReader(r io.Reader) *pktReader {
+	return &pktReader{r: bufio.NewReader(r)}
+}
+
+// next reads one packet, returning the payload of data packets.
+func (p *pktReader) next() (pktKind, []byte, error) {
+	if _, err := io.ReadFull(p.r, p.hdr[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	n, err := strconv.ParseUint(string(p.hdr[:]), 16, 16)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid pkt-line length %q", p.hdr[:])
+	}
+
+	switch n {
+	case 0:
+		return pktFlush, nil, nil
+	case 1:
+		return pktDelim, nil, nil
+	case 2:
+		return pktResponseEnd, nil, nil
+	case 3:
+		return 0, nil, fmt.Errorf("invalid pkt-line length %q", p.hdr[:])
+	}
+
+	if n > maxPktLen {
+		return 0, nil, fmt.Errorf("pkt-line too long: %d", n)
+	}
+
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(p.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return pktData, payload, nil
+}
+
+// nextLine reads a data packet and strips its trailing newline. Special
+// packets are returned with an empty line.
+func (p *pktReader) nextLine() (pktKind, string, error) {
+	kind, payload, err := p.next()
+	if err != nil || kind != pktData {
+		return kind, "", err
+	}
+	return kind, string(bytes.TrimSuffix(payload, []byte("\n"))), nil
+}
@@ -0,0 +1,77 @@
+package gitproto
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPktWriter(t *testing.T) {
+	var w pktWriter
+	w.line("command=%s", "ls-refs")
+	w.delim()
+	w.line("peel")
+	w.flush()
+
+	want := "0014command=ls-refs\n" + "0001" + "0009peel\n" + "0000"
+	if got := string(w.bytes()); got != want {
+		t.Errorf("pktWriter wrote %q, want %q", got, want)
+	}
+}
+
+func TestPktReader(t *testing.T) {
+	type packet struct {
+		kind pktKind
+		line string
+	}
+	input := "000eversion 2\n" + "0000" + "0001" + "0002" + "0007raw" + "0005\n"
+	want := []packet{
+		{pktData, "version 2"},
+		{pktFlush, ""},
+		{pktDelim, ""},
+		{pktResponseEnd, ""},
+		{pktData, "raw"},
+		{pktData, ""},
+	}
+
+	pkts := newPktReader(strings.NewReader(input))
+	for i, w := range want {
+		kind, line, err := pkts.nextLine()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if kind != w.kind || line != w.line {
+			t.Errorf("packet %d = %d %q, want %d %q", i, kind, line, w.kind, w.line)
+		}
+	}
+	if _, _, err := pkts.next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("reading past the end = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestPktReaderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"short header", "00"},
+		{"invalid hex", "zz10data"},
+		{"reserved length", "0003"},
+		{"too long", "fff1"},
+		{"truncated payload", "0010short"},
+	}
+	for _, tt := range tests {
+		if _, _, err := newPktReader(strings.NewReader(tt.input)).next(); err == nil {
+			t.Errorf("%s: next(%q) succeeded, want an error", tt.name, tt.input)
+		}
+	}
+
+	// The longest allowed packet is accepted.
+	payload := strings.Repeat("x", maxPktLen-4)
+	kind, got, err := newPktReader(strings.NewReader("fff0" + payload)).next()
+	if err != nil || kind != pktData || len(got) != len(payload) {
+		t.Errorf("next of a maximal packet = %d, %d bytes, %v", kind, len(got), err)
+	}
+}
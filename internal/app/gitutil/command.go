@@ -12,6 +12,22 @@ import (
 
 const DefaultTimeout = 60 * time.Second
 
+// CommandError is returned when a git command fails. Stderr holds only what
+// git printed, Error also names the command.
+type CommandError struct {
+	Args   []string
+	Err    error
+	Stderr string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("git %s: %v (%s)", strings.Join(e.Args, " "), e.Err, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
 func RunGitCommand(ctx context.Context, dir string, args ...string) (string, error) {
 	if ctx == nil {
 		var cancel context.CancelFunc
@@ -28,8 +44,7 @@ func RunGitCommand(ctx context.Context, dir string, args ...string) (string, err
 
 	err := cmd.Run()
 	if err != nil {
-		cmdStr := fmt.Sprintf("git %s", strings.Join(args, " "))
-		return "", fmt.Errorf("%s: %w (%s)", cmdStr, err, stderr.String())
+		return "", &CommandError{Args: args, Err: err, Stderr: stderr.String()}
 	}
 
 	return stdout.String(), nil
@@ -52,8 +67,7 @@ func RunGitCommandWithInput(ctx context.Context, dir, input string, args ...stri
 
 	err := cmd.Run()
 	if err != nil {
-		cmdStr := fmt.Sprintf("git %s", strings.Join(args, " "))
-		return "", fmt.Errorf("%s: %w (%s)", cmdStr, err, stderr.String())
+		return "", &CommandError{Args: args, Err: err, Stderr: stderr.String()}
 	}
 
 	return stdout.String(), nil
@@ -0,0 +1,107 @@
+package gitutil
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+)
+
+// AuthenticatedURL returns the clone URL for repoURL with token embedded as
+// credentials in the form the provider expects.
+func AuthenticatedURL(repoURL, token string, provider model.ProviderType) string {
+	// Bare "host/owner/repo" URLs (github.com or an Enterprise Server host)
+	// are cloned over https so the token can be injected below.
+	if !strings.Contains(repoURL, "://") && !IsSCPLikeURL(repoURL) {
+		repoURL = "https://" + repoURL
+	}
+
+	if token == "" {
+		return repoURL
+	}
+
+	if strings.HasPrefix(repoURL, "https://") {
+		parts := strings.SplitN(repoURL[8:], "/", 2)
+		if len(parts) == 2 {
+			credentials := token
+			switch provider {
+			case model.ProviderTypeBitbucket:
+				// Bitbucket access tokens need a fixed user name, app passwords
+				// are already given as "username:app_password".
+				if !strings.Contains(credentials, ":") {
+					credentials = "x-token-auth:" + credentials
+				}
+			case model.ProviderTypeAzureDevOps:
+				// Azure DevOps ignores the user name and reads the PAT from
+				// the password.
+				credentials = "pat:" + credentials
+			}
+			host := parts[0]
+			if at := strings.LastIndex(host, "@"); at >= 0 {
+				host = host[at+1:]
+			}
+			return fmt.Sprintf("https://%s@%s/%s", credentials, host, parts[1])
+		}
+	}
+
+	return repoURL
+}
+
+// IsSCPLikeURL reports whether repoURL uses git's "user@host:path" syntax.
+func IsSCPLikeURL(repoURL string) bool {
+	colon := strings.Index(repoURL, ":")
+	slash := strings.Index(repoURL, "/")
+	return colon > 0 && (slash < 0 || colon < slash)
+}
+
+// DetectProvider guesses the hosting provider from the host of the
+// repository URL; Azure DevOps Server is recognised by "/_git/" in the path.
+// Unknown hosts fall back to GitHub.
+func DetectProvider(repoURL string) model.ProviderType {
+	host, repoPath := splitHost(repoURL)
+	hasHost := func(domain string) bool {
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+
+	switch {
+	case hasHost("github.com"):
+		return model.ProviderTypeGitHub
+	case hasHost("dev.azure.com"), hasHost("visualstudio.com"):
+		return model.ProviderTypeAzureDevOps
+	case hasHost("bitbucket.org"):
+		return model.ProviderTypeBitbucket
+	case hasHost("codeberg.org"):
+		return model.ProviderTypeGitea
+	case strings.Contains(host, "gitlab"):
+		return model.ProviderTypeGitLab
+	case strings.Contains(host, "gitea"), strings.Contains(host, "forgejo"):
+		return model.ProviderTypeGitea
+	case strings.Contains(repoPath, "/_git/"):
+		return model.ProviderTypeAzureDevOps
+	default:
+		return model.ProviderTypeGitHub
+	}
+}
+
+// splitHost returns the lowercased host name and the path of a URL, an
+// scp-like "user@host:path" or a bare "host/path".
+func splitHost(repoURL string) (host, repoPath string) {
+	switch {
+	case strings.Contains(repoURL, "://"):
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return "", ""
+		}
+		return strings.ToLower(u.Hostname()), u.Path
+	case IsSCPLikeURL(repoURL):
+		host, repoPath, _ = strings.Cut(repoURL, ":")
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		return strings.ToLower(host), "/" + repoPath
+	default:
+		host, repoPath, _ = strings.Cut(repoURL, "/")
+		return strings.ToLower(host), "/" + repoPath
+	}
+}
@@ -0,0 +1,43 @@
+package gitutil
+
+import (
+	"testing"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+)
+
+func TestDetectProvider(t *testing.T) {
+	tests := []struct {
+		url  string
+		want model.ProviderType
+	}{
+		{"https://github.com/owner/repo", model.ProviderTypeGitHub},
+		{"github.com/owner/repo", model.ProviderTypeGitHub},
+		{"git@github.com:owner/gitlab-tools.git", model.ProviderTypeGitHub},
+		{"https://github.com/owner/bitbucket.org-mirror", model.ProviderTypeGitHub},
+		{"https://ghe.example.com/owner/repo", model.ProviderTypeGitHub},
+		{"https://gitlab.com/group/sub/project", model.ProviderTypeGitLab},
+		{"https://gitlab.example.com/group/project.git", model.ProviderTypeGitLab},
+		{"ssh://git@gitlab.example.com:2222/group/project.git", model.ProviderTypeGitLab},
+		{"https://codeberg.org/owner/repo", model.ProviderTypeGitea},
+		{"https://codeberg.org/o/gitlab-tools", model.ProviderTypeGitea},
+		{"https://gitea.com/owner/repo", model.ProviderTypeGitea},
+		{"https://forgejo.example.org/owner/repo", model.ProviderTypeGitea},
+		{"https://bitbucket.org/workspace/repo", model.ProviderTypeBitbucket},
+		{"https://bitbucket.org/ws/gitea-chart", model.ProviderTypeBitbucket},
+		{"git@bitbucket.org:workspace/repo.git", model.ProviderTypeBitbucket},
+		{"https://user@BitBucket.org/workspace/repo", model.ProviderTypeBitbucket},
+		{"https://dev.azure.com/org/project/_git/repo", model.ProviderTypeAzureDevOps},
+		{"https://dev.azure.com/org/gitlab-mig/_git/r", model.ProviderTypeAzureDevOps},
+		{"https://org.visualstudio.com/project/_git/repo", model.ProviderTypeAzureDevOps},
+		{"git@ssh.dev.azure.com:v3/org/project/repo", model.ProviderTypeAzureDevOps},
+		{"https://tfs.example.com/tfs/Collection/project/_git/repo", model.ProviderTypeAzureDevOps},
+		{"https://example.com/gitlab/repo", model.ProviderTypeGitHub},
+		{"https://notgithub.com/owner/repo", model.ProviderTypeGitHub},
+	}
+	for _, tt := range tests {
+		if got := DetectProvider(tt.url); got != tt.want {
+			t.Errorf("DetectProvider(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -1,25 +1,103 @@
 package model
 
+import (
+	"time"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/pathfilter"
+)
+
 type MethodType string
 
 const (
-	MethodTypeSparse MethodType = "sparse"
-	MethodTypeAPI    MethodType = "api"
+	MethodTypeSparse  MethodType = "sparse"
+	MethodTypeAPI     MethodType = "api"
+	MethodTypeArchive MethodType = "archive"
 )
 
 type ProviderType string
 
 const (
-	ProviderTypeGitHub ProviderType = "github"
+	ProviderTypeGitHub      ProviderType = "github"
+	ProviderTypeGitLab      ProviderType = "gitlab"
+	ProviderTypeGitea       ProviderType = "gitea"
+	ProviderTypeBitbucket   ProviderType = "bitbucket"
+	ProviderTypeAzureDevOps ProviderType = "azure"
 )
 
+// DefaultConcurrency is the number of parallel file downloads used by the API
+// method when none is configured.
+const DefaultConcurrency = 8
+
+// DefaultTimeout bounds the git operations of a download when no timeout is
+// configured.
+const DefaultTimeout = 2 * time.Minute
+
 type DownloadOptions struct {
 	RepoURL   string
 	Subdir    string
 	OutputDir string
 	Branch    string
 	Token     string
+	APIURL    string
 	Method    MethodType
 	Provider  ProviderType
 	Quiet     bool
+
+	Concurrency int
+	// Timeout bounds the git operations of a download, such as a fetch or
+	// listing the remote refs. Zero means DefaultTimeout.
+	Timeout time.Duration
+
+	// Ref pins the download to a tag, commit or other ref instead of a
+	// branch. Downloaders resolve it to a full commit hash.
+	Ref string
+
+	// Paths lists every path to download when more than one was requested.
+	// When empty, Subdir and OutputDir describe the only path.
+	Paths []PathSpec
+
+	// Filter selects the files downloaded within directories, paths are
+	// matched relative to the directory. nil keeps everything.
+	Filter *pathfilter.Filter
+	// NoUpstreamIgnore skips the .gitsnipignore files published by the
+	// source repository.
+	NoUpstreamIgnore bool
+	// Symlinks decides how symbolic links inside the folder are written.
+	Symlinks output.Symlinks
+	// RecurseSubmodules downloads the submodules inside the folder at the
+	// commit the parent records, with the same method.
+	RecurseSubmodules bool
+
+	// OutputFormat selects between writing a directory tree and writing
+	// OutputFile as a tar.gz or zip archive. For archives the OutputDir of
+	// each path is a directory inside the archive.
+	OutputFormat output.Format
+	OutputFile   string
+	// OnConflict decides what happens to outputs that already have content.
+	OnConflict output.Conflict
+	// Sink receives the downloaded files, it is set up from OutputFormat.
+	Sink output.Sink
+}
+
+// PathSpec is a path within the repository and where it is saved.
+type PathSpec struct {
+	Subdir    string
+	OutputDir string
+}
+
+// Targets returns the paths to download, Paths or else Subdir alone.
+func (o DownloadOptions) Targets() []PathSpec {
+	if len(o.Paths) > 0 {
+		return o.Paths
+	}
+	return []PathSpec{{Subdir: o.Subdir, OutputDir: o.OutputDir}}
+}
+
+// GitTimeout returns Timeout, or DefaultTimeout when none is set.
+func (o DownloadOptions) GitTimeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return DefaultTimeout
 }
@@ -0,0 +1,302 @@
+package output
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dagimg-dot/gitsnip/internal/util"
+)
+
+// entryWriter appends entries to an archive format.
+type entryWriter interface {
+	writeDir(name string, modTime time.Time) error
+	writeFile(name string, data []byte, executable bool, modTime time.Time) error
+	writeSymlink(name, target string, modTime time.Time) error
+	Close() error
+}
+
+// archiveSink collects the download in a single archive. An archive on disk
+// is written to a temporary file next to its path and renamed into place by
+// Close, so a failed download leaves no partial archive behind.
+type archiveSink struct {
+	mu      sync.Mutex
+	writer  entryWriter
+	dirs    map[string]bool
+	modTime time.Time
+
+	// path and file are unset when the archive is streamed.
+	path string
+	file *os.File
+}
+
+// newStreamArchive writes an archive to w as the download proceeds.
+func newStreamArchive(w io.Writer, newWriter func(io.Writer) entryWriter) *archiveSink {
+	return &archiveSink{
+		writer:  newWriter(w),
+		dirs:    map[string]bool{".": true},
+		modTime: time.Now(),
+	}
+}
+
+func newArchiveSink(archivePath string, newWriter func(io.Writer) entryWriter) (*archiveSink, error) {
+	dir := filepath.Dir(archivePath)
+	if err := util.EnsureDir(dir); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	file, err := os.CreateTemp(dir, "."+filepath.Base(archivePath)+".*.part")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive %s: %w", archivePath, err)
+	}
+
+	return &archiveSink{
+		path:    archivePath,
+		file:    file,
+		writer:  newWriter(file),
+		dirs:    map[string]bool{".": true},
+		modTime: time.Now(),
+	}, nil
+}
+
+// entryName turns a sink path into a slash separated archive entry name.
+func entryName(p string) (string, error) {
+	name := path.Clean(filepath.ToSlash(p))
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("path %s is outside the archive", p)
+	}
+	return name, nil
+}
+
+func (s *archiveSink) MkdirAll(p string) error {
+	name, err := entryName(p)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mkdirAll(name)
+}
+
+// mkdirAll adds entries for name and its parents that were not added yet.
+func (s *archiveSink) mkdirAll(name string) error {
+	if s.dirs[name] {
+		return nil
+	}
+	if err := s.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	if err := s.writer.writeDir(name, s.modTime); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	s.dirs[name] = true
+	return nil
+}
+
+func (s *archiveSink) WriteFile(p string, content io.Reader, executable bool) error {
+	name, err := entryName(p)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return fmt.Errorf("path %s is not a file", p)
+	}
+
+	// Entries are written one at a time and tar needs the size up front, so
+	// the content is read before taking the lock.
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("failed to read content of %s: %w", p, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	if err := s.writer.writeFile(name, data, executable, s.modTime); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+func (s *archiveSink) Symlink(p, target string) error {
+	name, err := entryName(p)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return fmt.Errorf("path %s is not a link", p)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	if err := s.writer.writeSymlink(name, target, s.modTime); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+func (s *archiveSink) IsDir(p string) bool {
+	name, err := entryName(p)
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirs[name]
+}
+
+func (s *archiveSink) Close() error {
+	if err := s.writer.Close(); err != nil {
+		s.Abort()
+		return fmt.Errorf("failed to write archive %s: %w", s.path, err)
+	}
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.Close(); err != nil {
+		s.Abort()
+		return fmt.Errorf("failed to write archive %s: %w", s.path, err)
+	}
+	if err := os.Chmod(s.file.Name(), 0644); err != nil {
+		s.Abort()
+		return fmt.Errorf("failed to set permissions on archive %s: %w", s.path, err)
+	}
+	if err := os.Rename(s.file.Name(), s.path); err != nil {
+		s.Abort()
+		return fmt.Errorf("failed to write archive %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// Abort removes the temporary file, a streamed archive simply ends.
+func (s *archiveSink) Abort() {
+	if s.file == nil {
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// tarWriter writes a tar archive, gzip compressed when gz is set.
+type tarWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarWriter(w io.Writer) entryWriter {
+	return &tarWriter{tw: tar.NewWriter(w)}
+}
+
+func newTarGzWriter(w io.Writer) entryWriter {
+	gz := gzip.NewWriter(w)
+	return &tarWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (t *tarWriter) writeDir(name string, modTime time.Time) error {
+	return t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+}
+
+func (t *tarWriter) writeFile(name string, data []byte, executable bool, modTime time.Time) error {
+	mode := int64(0644)
+	if executable {
+		mode = 0755
+	}
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = t.tw.Write(data)
+	return err
+}
+
+func (t *tarWriter) writeSymlink(name, target string, modTime time.Time) error {
+	return t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: target,
+		Mode:     0777,
+		ModTime:  modTime,
+	})
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.gz == nil {
+		return nil
+	}
+	return t.gz.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func newZipWriter(w io.Writer) entryWriter {
+	return &zipWriter{zw: zip.NewWriter(w)}
+}
+
+func (z *zipWriter) writeDir(name string, modTime time.Time) error {
+	header := &zip.FileHeader{Name: name + "/", Modified: modTime}
+	header.SetMode(os.ModeDir | 0755)
+	_, err := z.zw.CreateHeader(header)
+	return err
+}
+
+func (z *zipWriter) writeFile(name string, data []byte, executable bool, modTime time.Time) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+	header.SetMode(0644)
+	if executable {
+		header.SetMode(0755)
+	}
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeSymlink stores the link target as the content of the entry, as Info-ZIP
+// does.
+func (z *zipWriter) writeSymlink(name, target string, modTime time.Time) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Store, Modified: modTime}
+	header.SetMode(os.ModeSymlink | 0777)
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
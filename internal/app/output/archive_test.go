@@ -0,0 +1,149 @@
+package output
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// readArchive returns the entries of an archive file as name=mode, with the
+// link target or content of files appended.
+func readArchive(t *testing.T, format Format, file string) string {
+	t.Helper()
+	var entries []string
+	switch format {
+	case FormatZip:
+		zr, err := zip.OpenReader(file)
+		if err != nil {
+			t.Fatalf("opening zip: %v", err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			entry := f.Name + "=" + f.Mode().String()
+			if !f.Mode().IsDir() {
+				r, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, _ := io.ReadAll(r)
+				r.Close()
+				entry += ":" + string(content)
+			}
+			entries = append(entries, entry)
+		}
+	default:
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if format == FormatTarGz {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatalf("opening gzip: %v", err)
+			}
+			r = gz
+		}
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading tar: %v", err)
+			}
+			info := header.FileInfo()
+			entry := header.Name + "=" + info.Mode().String()
+			switch {
+			case header.Typeflag == tar.TypeSymlink:
+				entry += ":" + header.Linkname
+			case !info.IsDir():
+				content, _ := io.ReadAll(tr)
+				entry += ":" + string(content)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, " ")
+}
+
+func TestArchiveSink(t *testing.T) {
+	want := "src/=drwxr-xr-x src/a.txt=-rw-r--r--:a src/bin/=drwxr-xr-x src/bin/run=-rwxr-xr-x:run src/link=Lrwxrwxrwx:a.txt"
+	for _, format := range []Format{FormatTar, FormatTarGz, FormatZip} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "out."+string(format))
+			sink, err := NewArchive(format, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Parents are added once, whether created or implied by a file.
+			if err := sink.MkdirAll("src"); err != nil {
+				t.Fatalf("MkdirAll: %v", err)
+			}
+			if err := sink.WriteFile("src/a.txt", strings.NewReader("a"), false); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			if err := sink.WriteFile("src/bin/run", strings.NewReader("run"), true); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			if err := sink.Symlink("src/link", "a.txt"); err != nil {
+				t.Fatalf("Symlink: %v", err)
+			}
+			if !sink.IsDir("src/bin") || sink.IsDir("src/a.txt") {
+				t.Error("IsDir does not follow the entries written")
+			}
+			if _, err := os.Stat(file); err == nil {
+				t.Fatal("archive is visible before Close")
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			if got := readArchive(t, format, file); got != want {
+				t.Errorf("entries = %s, want %s", got, want)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("%d entries next to the archive, want no temporary file left", len(entries))
+			}
+		})
+	}
+}
+
+func TestArchiveSinkAbort(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewArchive(FormatZip, filepath.Join(dir, "nested", "out.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteFile("a.txt", strings.NewReader("a"), false); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	sink.Abort()
+
+	if entries, _ := os.ReadDir(filepath.Join(dir, "nested")); len(entries) != 0 {
+		t.Errorf("entries after Abort = %v, want none", entries)
+	}
+}
+
+func TestArchiveSinkOutsidePaths(t *testing.T) {
+	sink, err := NewArchive(FormatTar, filepath.Join(t.TempDir(), "out.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Abort()
+	for _, p := range []string{"../a.txt", "/a.txt", "."} {
+		if err := sink.WriteFile(p, strings.NewReader("a"), false); err == nil {
+			t.Errorf("WriteFile(%q) succeeded", p)
+		}
+	}
+}
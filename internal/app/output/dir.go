@@ -0,0 +1,472 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dagimg-dot/gitsnip/internal/util"
+)
+
+// NewDirSink returns a sink that writes files to the filesystem. roots are
+// the output directories of the download. Nothing is visible at a root until
+// Close: a root written as a directory is assembled in a staging directory
+// next to it and renamed into place, single files are kept as temporary
+// files until then. ConflictFail and ConflictSkip start from an empty
+// staging directory like ConflictClean; a root that turns out to be written
+// as a directory is refused or skipped when it has content.
+func NewDirSink(conflict Conflict, roots []string, quiet bool) Sink {
+	s := &dirSink{
+		conflict: conflict,
+		quiet:    quiet,
+		roots:    make(map[string]string),
+		files:    make(map[string]string),
+		skipped:  make(map[string]bool),
+	}
+	for _, root := range roots {
+		s.roots[filepath.Clean(root)] = ""
+	}
+	return s
+}
+
+type dirSink struct {
+	conflict Conflict
+	quiet    bool
+
+	mu sync.Mutex
+	// roots maps each output directory to its staging directory, empty
+	// until the root is first created as a directory.
+	roots map[string]string
+	// files maps files written outside a staged root to their temporary
+	// file.
+	files map[string]string
+	// skipped are the roots left alone under ConflictSkip, writes below
+	// them are dropped.
+	skipped map[string]bool
+	// madeDirs are the parents of staging directories created for them,
+	// deepest first, which Abort removes again.
+	madeDirs []string
+}
+
+// rootOf returns the root that path lies in.
+func (s *dirSink) rootOf(path string) (string, bool) {
+	for root := range s.roots {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+// inSkipped reports whether path lies in a skipped root.
+func (s *dirSink) inSkipped(path string) bool {
+	root, ok := s.rootOf(path)
+	return ok && s.skipped[root]
+}
+
+// stagedPath maps path below a staged root into its staging directory.
+func (s *dirSink) stagedPath(path string) (string, bool) {
+	root, ok := s.rootOf(path)
+	if !ok || s.roots[root] == "" {
+		return path, false
+	}
+	rel, _ := filepath.Rel(root, path)
+	return filepath.Join(s.roots[root], rel), true
+}
+
+// stage creates the staging directory of root. With ConflictOverwrite and
+// ConflictMerge it starts as a copy of the existing output, made of hard
+// links where possible.
+func (s *dirSink) stage(root string) error {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", root, err)
+	}
+	parent := filepath.Dir(absRoot)
+	for dir := parent; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		s.madeDirs = append(s.madeDirs, dir)
+	}
+	if err := util.EnsureDir(parent); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", parent, err)
+	}
+
+	staging, err := os.MkdirTemp(parent, "."+filepath.Base(absRoot)+".gitsnip-*")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory for %s: %w", root, err)
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("failed to set permissions on %s: %w", staging, err)
+	}
+
+	if s.conflict == ConflictOverwrite || s.conflict == ConflictMerge {
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			if err := linkTree(root, staging); err != nil {
+				os.RemoveAll(staging)
+				return fmt.Errorf("failed to stage existing content of %s: %w", root, err)
+			}
+		}
+	}
+
+	s.roots[root] = staging
+	return nil
+}
+
+func (s *dirSink) MkdirAll(path string) error {
+	path = filepath.Clean(path)
+
+	s.mu.Lock()
+	if root, ok := s.rootOf(path); ok && s.roots[root] == "" && !s.skipped[root] {
+		// Whether a root is a file or a directory is only known now, a
+		// file lands inside an existing directory and was checked before.
+		if (s.conflict == ConflictFail || s.conflict == ConflictSkip) && Occupied(root) {
+			if s.conflict == ConflictFail {
+				s.mu.Unlock()
+				return ExistsError(root)
+			}
+			s.skipped[root] = true
+			if !s.quiet {
+				fmt.Fprintf(os.Stderr, "Skipping, %s already exists\n", root)
+			}
+		} else if err := s.stage(root); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	if s.inSkipped(path) {
+		s.mu.Unlock()
+		return nil
+	}
+	target, staged := s.stagedPath(path)
+	s.mu.Unlock()
+
+	// A link left by an earlier download would lead the files elsewhere.
+	if info, err := os.Lstat(target); staged && err == nil && info.Mode()&fs.ModeSymlink != 0 && s.conflict != ConflictMerge {
+		if err := os.Remove(target); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", path, err)
+		}
+	}
+	return util.EnsureDir(target)
+}
+
+func (s *dirSink) WriteFile(path string, content io.Reader, executable bool) error {
+	path = filepath.Clean(path)
+
+	s.mu.Lock()
+	if s.inSkipped(path) {
+		s.mu.Unlock()
+		return nil
+	}
+	target, staged := s.stagedPath(path)
+	s.mu.Unlock()
+
+	if s.conflict == ConflictMerge {
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		}
+	}
+
+	// Files of a staged root are written in place, the root is swapped as a
+	// whole. Other files wait as temporary files next to their target.
+	var err error
+	if staged {
+		err = util.SaveToFile(target, content)
+	} else {
+		target, err = util.SaveToTempFile(path, content)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Git tracks no other permission bits, files keep the 0644 they were
+	// written with otherwise.
+	if executable {
+		if err := os.Chmod(target, 0755); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", path, err)
+		}
+	}
+
+	if !staged {
+		s.mu.Lock()
+		if previous, ok := s.files[path]; ok {
+			os.Remove(previous)
+		}
+		s.files[path] = target
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *dirSink) Symlink(path, target string) error {
+	path = filepath.Clean(path)
+
+	s.mu.Lock()
+	if s.inSkipped(path) {
+		s.mu.Unlock()
+		return nil
+	}
+	link, staged := s.stagedPath(path)
+	s.mu.Unlock()
+
+	if _, err := os.Lstat(link); err == nil {
+		if s.conflict == ConflictMerge {
+			return nil
+		}
+		if staged {
+			if err := os.RemoveAll(link); err != nil {
+				return fmt.Errorf("failed to replace %s: %w", path, err)
+			}
+		}
+	}
+
+	// Like files, a link outside a staged root waits under a temporary name
+	// next to its target.
+	if !staged {
+		temp, err := util.SymlinkToTemp(filepath.FromSlash(target), path)
+		if err != nil {
+			return err
+		}
+		link = temp
+	} else {
+		if err := util.EnsureDir(filepath.Dir(link)); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		if err := os.Symlink(filepath.FromSlash(target), link); err != nil {
+			return fmt.Errorf("failed to create link %s: %w", path, err)
+		}
+	}
+
+	if !staged {
+		s.mu.Lock()
+		if previous, ok := s.files[path]; ok {
+			os.Remove(previous)
+		}
+		s.files[path] = link
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *dirSink) IsDir(path string) bool {
+	s.mu.Lock()
+	target, _ := s.stagedPath(filepath.Clean(path))
+	s.mu.Unlock()
+
+	info, err := os.Stat(target)
+	return err == nil && info.IsDir()
+}
+
+// swapped is an output moved into place, backup holds what was there before.
+// With entries set the content of the directories was moved instead of the
+// directories themselves.
+type swapped struct {
+	target  string
+	staged  string
+	backup  string
+	entries bool
+}
+
+// Close moves every staged root and file into place. When one of the moves
+// fails, those already made are undone and the previous content restored.
+func (s *dirSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := make(map[string]string)
+	for root, staging := range s.roots {
+		if staging != "" {
+			pending[root] = staging
+		}
+	}
+	for path, temp := range s.files {
+		pending[path] = temp
+	}
+	targets := make([]string, 0, len(pending))
+	for target := range pending {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	var done []swapped
+	for _, target := range targets {
+		swap, err := swapIn(pending[target], target)
+		if err != nil {
+			for i := len(done) - 1; i >= 0; i-- {
+				done[i].undo()
+			}
+			s.abort()
+			return fmt.Errorf("failed to move download into %s: %w", target, err)
+		}
+		done = append(done, swap)
+	}
+
+	for _, swap := range done {
+		if swap.backup != "" {
+			os.RemoveAll(swap.backup)
+		}
+		if swap.entries {
+			os.Remove(swap.staged)
+		}
+	}
+	s.roots = make(map[string]string)
+	s.files = make(map[string]string)
+	return nil
+}
+
+// swapIn renames staged to target, moving an existing target aside first.
+// A directory that cannot be moved, like the current one, has its entries
+// swapped instead.
+func swapIn(staged, target string) (swapped, error) {
+	swap := swapped{target: target, staged: staged}
+	if _, err := os.Lstat(target); err != nil {
+		return swap, os.Rename(staged, target)
+	}
+
+	swap.backup = staged + ".old"
+	if err := os.Rename(target, swap.backup); err != nil {
+		if info, statErr := os.Stat(target); statErr != nil || !info.IsDir() {
+			return swap, err
+		}
+		return swapEntries(swap)
+	}
+	if err := os.Rename(staged, target); err != nil {
+		os.Rename(swap.backup, target)
+		return swap, err
+	}
+	return swap, nil
+}
+
+func swapEntries(swap swapped) (swapped, error) {
+	swap.entries = true
+	if err := os.Mkdir(swap.backup, 0755); err != nil {
+		return swap, err
+	}
+	if err := moveEntries(swap.target, swap.backup); err != nil {
+		moveEntries(swap.backup, swap.target)
+		os.Remove(swap.backup)
+		return swap, err
+	}
+	if err := moveEntries(swap.staged, swap.target); err != nil {
+		moveEntries(swap.target, swap.staged)
+		moveEntries(swap.backup, swap.target)
+		os.Remove(swap.backup)
+		return swap, err
+	}
+	return swap, nil
+}
+
+// moveEntries renames every entry of the directory from into to.
+func moveEntries(from, to string) error {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(from, entry.Name()), filepath.Join(to, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undo moves the new content back to its staging path and restores the
+// previous content.
+func (s swapped) undo() {
+	if s.entries {
+		moveEntries(s.target, s.staged)
+		moveEntries(s.backup, s.target)
+		os.Remove(s.backup)
+		return
+	}
+	os.Rename(s.target, s.staged)
+	if s.backup != "" {
+		os.Rename(s.backup, s.target)
+	}
+}
+
+// Abort removes the staged content, the outputs keep what they had.
+func (s *dirSink) Abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abort()
+}
+
+func (s *dirSink) abort() {
+	for root, staging := range s.roots {
+		if staging != "" {
+			os.RemoveAll(staging)
+			s.roots[root] = ""
+		}
+	}
+	for path, temp := range s.files {
+		os.Remove(temp)
+		delete(s.files, path)
+	}
+	for _, dir := range s.madeDirs {
+		os.Remove(dir)
+	}
+	s.madeDirs = nil
+}
+
+// linkTree recreates the tree at src below dst, hard linking files and
+// copying them where linking is not possible.
+func linkTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+			return copyRegular(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyRegular(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,255 @@
+package output
+
+import (
+	stderrors "errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+// writeTree creates files, given by slash separated path, below dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the files below dir by slash separated path.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	filepath.WalkDir(dir, func(p string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	return files
+}
+
+// treeString formats files for comparison and messages.
+func treeString(files map[string]string) string {
+	var entries []string
+	for name, content := range files {
+		entries = append(entries, name+"="+content)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, " ")
+}
+
+// writeDownload writes both.txt and sub/new.txt, both "new", below out.
+func writeDownload(t *testing.T, sink Sink, out string) {
+	t.Helper()
+	if err := sink.WriteFile(filepath.Join(out, "both.txt"), strings.NewReader("new"), false); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := sink.MkdirAll(filepath.Join(out, "sub")); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := sink.WriteFile(filepath.Join(out, "sub", "new.txt"), strings.NewReader("new"), false); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestDirSinkConflict(t *testing.T) {
+	existing := map[string]string{"keep.txt": "old", "both.txt": "old"}
+	tests := []struct {
+		conflict Conflict
+		wantErr  error
+		want     map[string]string
+	}{
+		{ConflictFail, errors.ErrOutputExists, existing},
+		{ConflictSkip, nil, existing},
+		{ConflictOverwrite, nil, map[string]string{"keep.txt": "old", "both.txt": "new", "sub/new.txt": "new"}},
+		{ConflictClean, nil, map[string]string{"both.txt": "new", "sub/new.txt": "new"}},
+		{ConflictMerge, nil, map[string]string{"keep.txt": "old", "both.txt": "old", "sub/new.txt": "new"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.conflict), func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			writeTree(t, out, existing)
+
+			sink := NewDirSink(tt.conflict, []string{out}, true)
+			err := sink.MkdirAll(out)
+			if err == nil {
+				writeDownload(t, sink, out)
+				// Nothing reaches the output before Close.
+				if got := readTree(t, out); treeString(got) != treeString(existing) {
+					t.Errorf("output before Close = %s", treeString(got))
+				}
+				err = sink.Close()
+			} else {
+				sink.Abort()
+			}
+
+			if !stderrors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got := readTree(t, out); treeString(got) != treeString(tt.want) {
+				t.Errorf("output = %s, want %s", treeString(got), treeString(tt.want))
+			}
+			if entries, _ := os.ReadDir(filepath.Dir(out)); len(entries) != 1 {
+				t.Errorf("%d entries next to the output, want no staging left", len(entries))
+			}
+		})
+	}
+}
+
+func TestDirSinkConflictEmptyOutput(t *testing.T) {
+	for _, conflict := range []Conflict{ConflictFail, ConflictSkip} {
+		out := filepath.Join(t.TempDir(), "out")
+		if err := os.Mkdir(out, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		sink := NewDirSink(conflict, []string{out}, true)
+		if err := sink.MkdirAll(out); err != nil {
+			t.Fatalf("%s: MkdirAll of an empty output: %v", conflict, err)
+		}
+		if err := sink.WriteFile(filepath.Join(out, "a.txt"), strings.NewReader("a"), true); err != nil {
+			t.Fatalf("%s: WriteFile: %v", conflict, err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("%s: Close: %v", conflict, err)
+		}
+
+		info, err := os.Stat(filepath.Join(out, "a.txt"))
+		if err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("%s: a.txt = %v, %v, want an executable file", conflict, info, err)
+		}
+	}
+}
+
+func TestDirSinkSymlink(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "link")
+
+	sink := NewDirSink(ConflictOverwrite, []string{link}, true)
+	if err := sink.Symlink(link, "a/target.txt"); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	// Replacing the pending link leaves no temporary name behind.
+	if err := sink.Symlink(link, "b/target.txt"); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if _, err := os.Lstat(link); err == nil {
+		t.Fatal("link is visible before Close")
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if got, err := os.Readlink(link); err != nil || got != filepath.FromSlash("b/target.txt") {
+		t.Errorf("Readlink = %q, %v, want b/target.txt", got, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("output has %d entries, want only the link", len(entries))
+	}
+}
+
+func TestDirSinkAbort(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	writeTree(t, existing, map[string]string{"keep.txt": "old"})
+	fresh := filepath.Join(dir, "new", "deeper", "out")
+
+	sink := NewDirSink(ConflictClean, []string{existing, fresh}, true)
+	for _, out := range []string{existing, fresh} {
+		if err := sink.MkdirAll(out); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		writeDownload(t, sink, out)
+	}
+	sink.Abort()
+
+	if got := readTree(t, existing); treeString(got) != "keep.txt=old" {
+		t.Errorf("existing output = %s, want it unchanged", treeString(got))
+	}
+	// Parents created for the staging directory are removed again.
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "existing" {
+		t.Errorf("entries after Abort = %v, want only the existing output", entries)
+	}
+}
+
+func TestDirSinkCloseRollback(t *testing.T) {
+	dir := t.TempDir()
+	first, file, last := filepath.Join(dir, "a"), filepath.Join(dir, "b.txt"), filepath.Join(dir, "c")
+	writeTree(t, first, map[string]string{"both.txt": "old"})
+	writeTree(t, dir, map[string]string{"b.txt": "old"})
+	writeTree(t, last, map[string]string{"both.txt": "old"})
+	before := treeString(readTree(t, dir))
+
+	sink := NewDirSink(ConflictOverwrite, []string{first, file, last}, true)
+	for _, out := range []string{first, last} {
+		if err := sink.MkdirAll(out); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		writeDownload(t, sink, out)
+	}
+	if err := sink.WriteFile(file, strings.NewReader("new"), false); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// The last output cannot be moved into place, the earlier ones are
+	// moved back.
+	os.RemoveAll(sink.(*dirSink).roots[last])
+	if err := sink.Close(); err == nil {
+		t.Fatal("Close succeeded without the staged output")
+	}
+
+	if got := treeString(readTree(t, dir)); got != before {
+		t.Errorf("outputs after a failed Close = %s, want %s", got, before)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("%d entries after a failed Close, want the 3 outputs only", len(entries))
+	}
+}
+
+func TestSwapInEntries(t *testing.T) {
+	// A target that cannot be renamed, like the working directory, has its
+	// entries swapped. undo puts them back.
+	dir := t.TempDir()
+	target, staged := filepath.Join(dir, "out"), filepath.Join(dir, "staged")
+	writeTree(t, target, map[string]string{"old.txt": "old"})
+	writeTree(t, staged, map[string]string{"new.txt": "new"})
+
+	swap, err := swapEntries(swapped{target: target, staged: staged, backup: staged + ".old"})
+	if err != nil {
+		t.Fatalf("swapEntries: %v", err)
+	}
+	if got := treeString(readTree(t, target)); got != "new.txt=new" {
+		t.Errorf("target after the swap = %s", got)
+	}
+
+	swap.undo()
+	if got := treeString(readTree(t, target)); got != "old.txt=old" {
+		t.Errorf("target after undo = %s", got)
+	}
+	if got := treeString(readTree(t, staged)); got != "new.txt=new" {
+		t.Errorf("staging after undo = %s", got)
+	}
+	if _, err := os.Stat(swap.backup); err == nil {
+		t.Error("backup left behind after undo")
+	}
+}
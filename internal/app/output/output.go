@@ -0,0 +1,259 @@
+// Package output writes downloaded files to their destination, a directory
+// tree or a tar, tar.gz or zip archive on disk or on standard output.
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/safepath"
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+// Format is the kind of output a download is written to.
+type Format string
+
+const (
+	FormatDir   Format = "dir"
+	FormatTar   Format = "tar"
+	FormatTarGz Format = "tar.gz"
+	FormatZip   Format = "zip"
+)
+
+// Stdout is the output path that streams the download to standard output.
+const Stdout = "-"
+
+// Conflict is the policy for an output that already has content.
+type Conflict string
+
+const (
+	// ConflictFail refuses to write to a non-empty output.
+	ConflictFail Conflict = "fail"
+	// ConflictOverwrite replaces existing files and keeps the others.
+	ConflictOverwrite Conflict = "overwrite"
+	// ConflictSkip leaves a non-empty output untouched and skips its path.
+	ConflictSkip Conflict = "skip"
+	// ConflictClean replaces the output with the download, removing every
+	// file that is not part of it, so the output mirrors the source.
+	ConflictClean Conflict = "clean"
+	// ConflictMerge adds missing files and keeps existing ones unchanged.
+	ConflictMerge Conflict = "merge"
+)
+
+// ParseConflict parses the value of --on-conflict.
+func ParseConflict(name string) (Conflict, error) {
+	switch conflict := Conflict(strings.ToLower(name)); conflict {
+	case ConflictFail, ConflictOverwrite, ConflictSkip, ConflictClean, ConflictMerge:
+		return conflict, nil
+	default:
+		return "", fmt.Errorf("unsupported conflict policy: %s", name)
+	}
+}
+
+// ExistsError reports that the output path already has content.
+func ExistsError(path string) error {
+	return &errors.AppError{
+		Err:     errors.ErrOutputExists,
+		Message: fmt.Sprintf("Output '%s' already exists and is not empty", strings.TrimSuffix(path, "/")),
+		Hint:    "Choose another output or pass --on-conflict overwrite, merge, clean or skip",
+	}
+}
+
+// Occupied reports whether path is a file or a non-empty directory.
+func Occupied(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if !info.IsDir() {
+		return true
+	}
+	entries, err := os.ReadDir(path)
+	return err != nil || len(entries) > 0
+}
+
+// ParseFormat parses the value of --output-format.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "dir", "directory":
+		return FormatDir, nil
+	case "tar":
+		return FormatTar, nil
+	case "tar.gz", "tgz":
+		return FormatTarGz, nil
+	case "zip":
+		return FormatZip, nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", name)
+	}
+}
+
+// FormatFromName infers the format from the extension of an output path.
+// Paths without an archive extension are directories.
+func FormatFromName(name string) Format {
+	lowered := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lowered, ".tar.gz"), strings.HasSuffix(lowered, ".tgz"):
+		return FormatTarGz
+	case strings.HasSuffix(lowered, ".tar"):
+		return FormatTar
+	case strings.HasSuffix(lowered, ".zip"):
+		return FormatZip
+	default:
+		return FormatDir
+	}
+}
+
+// Sink receives the directories and files of a download. Paths are
+// filesystem paths for a directory sink and paths inside the archive for an
+// archive sink. WriteFile may be called concurrently.
+type Sink interface {
+	MkdirAll(path string) error
+	WriteFile(path string, content io.Reader, executable bool) error
+	// Symlink creates a symbolic link at path pointing at target, a slash
+	// separated path relative to the link.
+	Symlink(path, target string) error
+	// IsDir reports whether path is an existing directory.
+	IsDir(path string) bool
+	// Close completes the output after a successful download.
+	Close() error
+	// Abort discards what a failed download has written where possible.
+	Abort()
+}
+
+// NewArchive returns a sink that writes file as an archive of format.
+func NewArchive(format Format, file string) (Sink, error) {
+	switch format {
+	case FormatTar:
+		return newArchiveSink(file, newTarWriter)
+	case FormatTarGz:
+		return newArchiveSink(file, newTarGzWriter)
+	case FormatZip:
+		return newArchiveSink(file, newZipWriter)
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WithNameCheck wraps sink so that it refuses paths that only differ in case
+// from one written before.
+func WithNameCheck(sink Sink) Sink {
+	return &namesSink{Sink: sink, names: safepath.NewNames()}
+}
+
+type namesSink struct {
+	Sink
+	names *safepath.Names
+}
+
+func (s *namesSink) MkdirAll(path string) error {
+	if err := s.names.Add(path); err != nil {
+		return err
+	}
+	return s.Sink.MkdirAll(path)
+}
+
+func (s *namesSink) WriteFile(path string, content io.Reader, executable bool) error {
+	if err := s.names.Add(path); err != nil {
+		return err
+	}
+	return s.Sink.WriteFile(path, content, executable)
+}
+
+func (s *namesSink) Symlink(path, target string) error {
+	if err := s.names.Add(path); err != nil {
+		return err
+	}
+	return s.Sink.Symlink(path, target)
+}
+
+// Filter selects the entries CopyDirectory copies. Paths are slash separated
+// and relative to the source directory.
+type Filter interface {
+	Match(relPath string, isDir bool) bool
+	Prune(relPath string) bool
+}
+
+// CopyDirectory writes the content of the local directory src to dst in
+// sink. A nil filter copies everything. Directories the filter leaves out are
+// only created when files inside them are kept. Symlinks are passed to
+// sink as links and must not point outside src.
+func CopyDirectory(sink Sink, src, dst string, filter Filter) error {
+	if err := sink.MkdirAll(dst); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	return copyDirectory(sink, src, dst, "", filter)
+}
+
+func copyDirectory(sink Sink, src, dst, relDir string, filter Filter) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("failed to read source directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+		relPath := path.Join(relDir, entry.Name())
+
+		if entry.Type()&os.ModeSymlink != 0 {
+			if filter == nil || filter.Match(relPath, false) {
+				if err := copyLink(sink, srcPath, dstPath, relPath); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if entry.IsDir() {
+			if filter != nil && filter.Prune(relPath) {
+				continue
+			}
+			if filter == nil || filter.Match(relPath, true) {
+				if err := sink.MkdirAll(dstPath); err != nil {
+					return fmt.Errorf("failed to create directory %s: %w", dstPath, err)
+				}
+			}
+			if err := copyDirectory(sink, srcPath, dstPath, relPath, filter); err != nil {
+				return err
+			}
+		} else if filter == nil || filter.Match(relPath, false) {
+			if err := copyFile(sink, srcPath, dstPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func copyLink(sink Sink, src, dst, relPath string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return fmt.Errorf("failed to read link %s: %w", relPath, err)
+	}
+	target = filepath.ToSlash(target)
+	if err := safepath.CheckLink(relPath, target); err != nil {
+		return err
+	}
+	return sink.Symlink(dst, target)
+}
+
+func copyFile(sink Sink, src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
+	}
+
+	return sink.WriteFile(dst, srcFile, srcInfo.Mode()&0111 != 0)
+}
@@ -0,0 +1,116 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// NewStream returns a sink that writes the download to w as an archive of
+// format, a plain tar unless an archive format is given. With passFile a
+// download that turns out to be a single file is written as its raw bytes
+// instead, which callers allow when only one path was requested.
+func NewStream(w io.Writer, format Format, passFile bool) (Sink, error) {
+	newWriter := newTarWriter
+	switch format {
+	case "", FormatTar:
+	case FormatTarGz:
+		newWriter = newTarGzWriter
+	case FormatZip:
+		newWriter = newZipWriter
+	default:
+		return nil, fmt.Errorf("output format %s cannot be streamed", format)
+	}
+	return &streamSink{w: w, newWriter: newWriter, passFile: passFile}, nil
+}
+
+// streamSink decides on the first call: downloaders create the output
+// directory before any file below it, so a download that starts with a
+// file is a single file.
+type streamSink struct {
+	mu        sync.Mutex
+	w         io.Writer
+	newWriter func(io.Writer) entryWriter
+	passFile  bool
+
+	archive   *archiveSink
+	wroteFile bool
+}
+
+// archiveLocked returns the archive, starting it on first use.
+func (s *streamSink) archiveLocked() (*archiveSink, error) {
+	if s.wroteFile {
+		return nil, fmt.Errorf("only a single file can be written to standard output as is")
+	}
+	if s.archive == nil {
+		s.archive = newStreamArchive(s.w, s.newWriter)
+	}
+	return s.archive, nil
+}
+
+func (s *streamSink) MkdirAll(path string) error {
+	s.mu.Lock()
+	archive, err := s.archiveLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return archive.MkdirAll(path)
+}
+
+func (s *streamSink) WriteFile(path string, content io.Reader, executable bool) error {
+	s.mu.Lock()
+	if s.passFile && s.archive == nil && !s.wroteFile {
+		defer s.mu.Unlock()
+		s.wroteFile = true
+		if _, err := io.Copy(s.w, content); err != nil {
+			return fmt.Errorf("failed to write %s to standard output: %w", path, err)
+		}
+		return nil
+	}
+	archive, err := s.archiveLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return archive.WriteFile(path, content, executable)
+}
+
+func (s *streamSink) Symlink(path, target string) error {
+	s.mu.Lock()
+	archive, err := s.archiveLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return archive.Symlink(path, target)
+}
+
+// IsDir treats the root as a directory so a single file keeps its name
+// inside an archive.
+func (s *streamSink) IsDir(path string) bool {
+	s.mu.Lock()
+	archive := s.archive
+	s.mu.Unlock()
+	if archive == nil {
+		name, err := entryName(path)
+		return err == nil && name == "."
+	}
+	return archive.IsDir(path)
+}
+
+func (s *streamSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wroteFile {
+		return nil
+	}
+	// Even an empty download produces a valid archive.
+	archive, err := s.archiveLocked()
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func (s *streamSink) Abort() {}
@@ -0,0 +1,100 @@
+package output
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// tarNames returns the entry names of a tar archive, without trailing slashes.
+func tarNames(t *testing.T, data []byte) []string {
+	t.Helper()
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		names = append(names, strings.TrimSuffix(header.Name, "/"))
+	}
+}
+
+func TestStreamSingleFile(t *testing.T) {
+	var buf bytes.Buffer
+	sink, err := NewStream(&buf, FormatTar, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sink.IsDir(".") {
+		t.Error("IsDir(.) = false before anything was written")
+	}
+	if err := sink.WriteFile("a.txt", strings.NewReader("raw"), false); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// Once the file went out as is, nothing else can follow it.
+	if err := sink.WriteFile("b.txt", strings.NewReader("b"), false); err == nil {
+		t.Error("second WriteFile succeeded after a raw file")
+	}
+	if err := sink.MkdirAll("dir"); err == nil {
+		t.Error("MkdirAll succeeded after a raw file")
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if buf.String() != "raw" {
+		t.Errorf("output = %q, want the raw file", buf.String())
+	}
+}
+
+func TestStreamArchive(t *testing.T) {
+	tests := []struct {
+		name     string
+		passFile bool
+		write    func(Sink) error
+		want     string
+	}{
+		{"directory first", true, func(s Sink) error {
+			if err := s.MkdirAll("src"); err != nil {
+				return err
+			}
+			return s.WriteFile("src/a.txt", strings.NewReader("a"), false)
+		}, "src src/a.txt"},
+		{"single file without passFile", false, func(s Sink) error {
+			return s.WriteFile("a.txt", strings.NewReader("a"), false)
+		}, "a.txt"},
+		{"link first", true, func(s Sink) error {
+			return s.Symlink("link", "a.txt")
+		}, "link"},
+		{"empty", true, func(s Sink) error { return nil }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			sink, err := NewStream(&buf, FormatTar, tt.passFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.write(sink); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if got := strings.Join(tarNames(t, buf.Bytes()), " "); got != tt.want {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamFormat(t *testing.T) {
+	if _, err := NewStream(io.Discard, FormatDir, false); err == nil {
+		t.Error("NewStream accepted the dir format")
+	}
+}
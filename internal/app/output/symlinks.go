@@ -0,0 +1,260 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Symlinks is the policy for symbolic links inside a downloaded folder.
+// Downloaders refuse links that point outside the folder under every policy.
+type Symlinks string
+
+const (
+	// SymlinksPreserve recreates links as links.
+	SymlinksPreserve Symlinks = "preserve"
+	// SymlinksFollow writes the file or directory a link points to in its
+	// place.
+	SymlinksFollow Symlinks = "follow"
+	// SymlinksSkip leaves links out.
+	SymlinksSkip Symlinks = "skip"
+)
+
+// ParseSymlinks parses the value of --symlinks.
+func ParseSymlinks(name string) (Symlinks, error) {
+	switch symlinks := Symlinks(strings.ToLower(name)); symlinks {
+	case SymlinksPreserve, SymlinksFollow, SymlinksSkip:
+		return symlinks, nil
+	default:
+		return "", fmt.Errorf("unsupported symlink policy: %s", name)
+	}
+}
+
+// WithSymlinks wraps sink to apply policy to the links downloaders report.
+// Following a link needs the content of its target, which may arrive before
+// or after the link, so under SymlinksFollow every file is also kept in a
+// temporary directory until Close writes the links.
+func WithSymlinks(sink Sink, policy Symlinks, quiet bool) Sink {
+	switch policy {
+	case SymlinksSkip:
+		return &skipLinksSink{Sink: sink}
+	case SymlinksFollow:
+		return &followLinksSink{
+			Sink:  sink,
+			quiet: quiet,
+			dirs:  make(map[string]bool),
+			files: make(map[string]keptFile),
+			links: make(map[string]string),
+		}
+	default:
+		return sink
+	}
+}
+
+type skipLinksSink struct {
+	Sink
+}
+
+func (s *skipLinksSink) Symlink(path, target string) error {
+	return nil
+}
+
+type followLinksSink struct {
+	Sink
+	quiet bool
+
+	mu    sync.Mutex
+	spool string
+	dirs  map[string]bool
+	files map[string]keptFile
+	// links maps each link to its target resolved against the link's
+	// directory.
+	links map[string]string
+}
+
+// keptFile is a copy of a written file in the spool directory.
+type keptFile struct {
+	spooled    string
+	executable bool
+}
+
+// key normalizes a sink path to a slash separated map key.
+func key(p string) string {
+	return path.Clean(filepath.ToSlash(p))
+}
+
+func (s *followLinksSink) MkdirAll(p string) error {
+	if err := s.Sink.MkdirAll(p); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.dirs[key(p)] = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *followLinksSink) WriteFile(p string, content io.Reader, executable bool) error {
+	s.mu.Lock()
+	if s.spool == "" {
+		spool, err := os.MkdirTemp("", "gitsnip-links-*")
+		if err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		s.spool = spool
+	}
+	spool := s.spool
+	s.mu.Unlock()
+
+	file, err := os.CreateTemp(spool, "file-*")
+	if err != nil {
+		return fmt.Errorf("failed to keep a copy of %s: %w", p, err)
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to keep a copy of %s: %w", p, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to keep a copy of %s: %w", p, err)
+	}
+	defer file.Close()
+
+	if err := s.Sink.WriteFile(p, file, executable); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.files[key(p)] = keptFile{spooled: file.Name(), executable: executable}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *followLinksSink) Symlink(p, target string) error {
+	link := key(p)
+	s.mu.Lock()
+	s.links[link] = path.Join(path.Dir(link), target)
+	s.mu.Unlock()
+	return nil
+}
+
+// maxLinkDepth bounds chains of links to links, as the kernel does.
+const maxLinkDepth = 40
+
+// resolve follows links from p until it reaches something that is not a
+// link.
+func (s *followLinksSink) resolve(p string) (string, bool) {
+	for depth := 0; depth < maxLinkDepth; depth++ {
+		target, ok := s.links[p]
+		if !ok {
+			return p, true
+		}
+		p = target
+	}
+	return "", false
+}
+
+// writeLinks writes the targets of all links in their place. A link to a
+// directory copies the directory, links inside it are followed as well.
+func (s *followLinksSink) writeLinks() error {
+	pending := make([]string, 0, len(s.links))
+	for link := range s.links {
+		pending = append(pending, link)
+	}
+	sort.Strings(pending)
+
+	for len(pending) > 0 {
+		link := pending[0]
+		pending = pending[1:]
+
+		target, ok := s.resolve(link)
+		if !ok {
+			return fmt.Errorf("too many levels of links at %s", link)
+		}
+
+		if file, ok := s.files[target]; ok {
+			if err := s.writeKept(link, file); err != nil {
+				return err
+			}
+			continue
+		}
+		if !s.dirs[target] {
+			if !s.quiet {
+				fmt.Fprintf(os.Stderr, "Skipping link %s, its target was not downloaded\n", link)
+			}
+			continue
+		}
+		if link == target || strings.HasPrefix(link, target+"/") {
+			return fmt.Errorf("link %s points at a directory containing it", link)
+		}
+
+		if err := s.Sink.MkdirAll(filepath.FromSlash(link)); err != nil {
+			return err
+		}
+		prefix := target + "/"
+		for dir := range s.dirs {
+			if rest, found := strings.CutPrefix(dir, prefix); found {
+				if err := s.Sink.MkdirAll(filepath.FromSlash(path.Join(link, rest))); err != nil {
+					return err
+				}
+			}
+		}
+		for name, file := range s.files {
+			if rest, found := strings.CutPrefix(name, prefix); found {
+				if err := s.writeKept(path.Join(link, rest), file); err != nil {
+					return err
+				}
+			}
+		}
+		for inner, innerTarget := range s.links {
+			if rest, found := strings.CutPrefix(inner, prefix); found {
+				copied := path.Join(link, rest)
+				if _, ok := s.links[copied]; !ok {
+					s.links[copied] = innerTarget
+					pending = append(pending, copied)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *followLinksSink) writeKept(p string, file keptFile) error {
+	content, err := os.Open(file.spooled)
+	if err != nil {
+		return fmt.Errorf("failed to read copy of %s: %w", p, err)
+	}
+	defer content.Close()
+	return s.Sink.WriteFile(filepath.FromSlash(p), content, file.executable)
+}
+
+func (s *followLinksSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.removeSpool()
+
+	if err := s.writeLinks(); err != nil {
+		s.Sink.Abort()
+		return err
+	}
+	return s.Sink.Close()
+}
+
+func (s *followLinksSink) Abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeSpool()
+	s.Sink.Abort()
+}
+
+func (s *followLinksSink) removeSpool() {
+	if s.spool != "" {
+		os.RemoveAll(s.spool)
+		s.spool = ""
+	}
+}
@@ -0,0 +1,114 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// linkDownload writes files and links below out through a sink following
+// links. Names are slash separated, a file named with a trailing slash is a
+// directory.
+func linkDownload(t *testing.T, out string, files map[string]string, links map[string]string) error {
+	t.Helper()
+	sink := WithSymlinks(NewDirSink(ConflictFail, []string{out}, true), SymlinksFollow, true)
+	if err := sink.MkdirAll(out); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	for name, content := range files {
+		p := filepath.Join(out, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := sink.MkdirAll(p); err != nil {
+				t.Fatalf("MkdirAll: %v", err)
+			}
+			continue
+		}
+		if err := sink.MkdirAll(filepath.Dir(p)); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := sink.WriteFile(p, strings.NewReader(content), strings.HasSuffix(name, ".sh")); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	for name, target := range links {
+		if err := sink.Symlink(filepath.Join(out, filepath.FromSlash(name)), target); err != nil {
+			t.Fatalf("Symlink: %v", err)
+		}
+	}
+	return sink.Close()
+}
+
+func TestFollowLinks(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	files := map[string]string{
+		"real.txt":  "r",
+		"run.sh":    "#!/bin/sh",
+		"dir/x.txt": "x",
+		"empty/":    "",
+	}
+	links := map[string]string{
+		// A chain, written in reverse order of resolution.
+		"l1":        "l2",
+		"l2":        "sub/../real.txt",
+		"exe":       "run.sh",
+		"ldir":      "dir",
+		"dir/inner": "../l1",
+		"lempty":    "empty",
+		"dangling":  "missing.txt",
+	}
+	if err := linkDownload(t, out, files, links); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	want := map[string]string{
+		"real.txt":   "r",
+		"run.sh":     "#!/bin/sh",
+		"exe":        "#!/bin/sh",
+		"l1":         "r",
+		"l2":         "r",
+		"dir/x.txt":  "x",
+		"dir/inner":  "r",
+		"ldir/x.txt": "x",
+		"ldir/inner": "r",
+	}
+	if got := readTree(t, out); treeString(got) != treeString(want) {
+		t.Errorf("output = %s, want %s", treeString(got), treeString(want))
+	}
+
+	for _, name := range []string{"l1", "ldir", "lempty"} {
+		info, err := os.Lstat(filepath.Join(out, name))
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			t.Errorf("%s = %v, %v, want a copy of the target", name, info, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(out, "exe")); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("exe = %v, %v, want the executable bit of run.sh", info, err)
+	}
+	if _, err := os.Lstat(filepath.Join(out, "dangling")); err == nil {
+		t.Error("a link whose target was not downloaded is written")
+	}
+}
+
+func TestFollowLinksErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		links map[string]string
+	}{
+		{"cycle", nil, map[string]string{"a": "b", "b": "a"}},
+		{"self", nil, map[string]string{"a": "a"}},
+		{"containing directory", map[string]string{"dir/x.txt": "x"}, map[string]string{"dir/up": ".."}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		out := filepath.Join(dir, "out")
+		if err := linkDownload(t, out, tt.files, tt.links); err == nil {
+			t.Errorf("%s: Close succeeded, want an error", tt.name)
+		}
+		// The failed download is aborted and leaves nothing behind.
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%s: %d entries left after the failure", tt.name, len(entries))
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Package pathfilter selects the files of a downloaded directory with
+// gitignore style patterns, e.g. "**/*_test.go" or "testdata/".
+package pathfilter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pattern is a single gitignore pattern.
+type pattern struct {
+	// body is the pattern as written, without "!", the leading "/" and the
+	// trailing "/".
+	body     string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	re       *regexp.Regexp
+}
+
+// Patterns is an ordered list of gitignore patterns. As in .gitignore the
+// last matching pattern decides and "!" negates.
+type Patterns []pattern
+
+// Parse compiles gitignore lines. Blank lines and "#" comments are skipped.
+func Parse(lines []string) (Patterns, error) {
+	var patterns Patterns
+	for _, line := range lines {
+		p, ok, err := parsePattern(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, nil
+}
+
+func parsePattern(line string) (p pattern, ok bool, err error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return p, false, nil
+	}
+	raw := line
+
+	if rest, found := strings.CutPrefix(line, "!"); found {
+		p.negate, line = true, rest
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if rest, found := strings.CutSuffix(line, "/"); found {
+		p.dirOnly, line = true, rest
+	}
+	// A slash anywhere but at the end anchors the pattern to the root,
+	// otherwise it matches a name at any depth.
+	p.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return p, false, fmt.Errorf("invalid pattern %q", raw)
+	}
+	p.body = line
+
+	expr := globToRegexp(line)
+	if !p.anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	if p.re, err = regexp.Compile("^" + expr + "$"); err != nil {
+		return p, false, fmt.Errorf("invalid pattern %q: %w", raw, err)
+	}
+	return p, true, nil
+}
+
+// globToRegexp translates "*", "?", "[...]" and "**" path segments.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case glob[i:] == "**" && (i == 0 || glob[i-1] == '/'):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Matches reports whether relPath, a slash separated path, is matched. A
+// matched directory matches everything below it.
+func (ps Patterns) Matches(relPath string, isDir bool) bool {
+	relPath = strings.Trim(relPath, "/")
+	for i := strings.IndexByte(relPath, '/'); i >= 0; i = nextSlash(relPath, i) {
+		if ps.matchOne(relPath[:i], true) {
+			return true
+		}
+	}
+	return ps.matchOne(relPath, isDir)
+}
+
+func nextSlash(s string, i int) int {
+	j := strings.IndexByte(s[i+1:], '/')
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+func (ps Patterns) matchOne(relPath string, isDir bool) bool {
+	matched := false
+	for _, p := range ps {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(relPath) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+// Filter keeps the files matched by an include list, all files when it is
+// empty, minus those matched by an exclude list. A nil Filter keeps
+// everything.
+type Filter struct {
+	include Patterns
+	exclude Patterns
+	// prefix is prepended to the paths matched against include and exclude
+	// when the filter of a folder applies inside a submodule below it.
+	prefix string
+	// ignore holds the lines of an ignore file, matched without prefix.
+	ignore Patterns
+}
+
+// New compiles include and exclude patterns. It returns nil when both are
+// empty.
+func New(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.include, err = Parse(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = Parse(exclude); err != nil {
+		return nil, err
+	}
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Match reports whether relPath is downloaded. For a directory it reports
+// whether the directory itself is created; Prune decides whether it is
+// walked.
+func (f *Filter) Match(relPath string, isDir bool) bool {
+	if f == nil {
+		return true
+	}
+	full := path.Join(f.prefix, relPath)
+	if f.exclude.Matches(full, isDir) || f.ignore.Matches(relPath, isDir) {
+		return false
+	}
+	return len(f.include) == 0 || f.include.Matches(full, isDir)
+}
+
+// Prune reports whether nothing below the directory relPath is downloaded.
+func (f *Filter) Prune(relPath string) bool {
+	return f != nil && (f.exclude.Matches(path.Join(f.prefix, relPath), true) || f.ignore.Matches(relPath, true))
+}
+
+// Below returns the filter for the directory dir, a submodule that is
+// downloaded on its own: paths below it are matched as dir/path. Ignore
+// lines are dropped, they belong to the parent repository.
+func (f *Filter) Below(dir string) *Filter {
+	if f == nil || (len(f.include) == 0 && len(f.exclude) == 0) {
+		return nil
+	}
+	return &Filter{include: f.include, exclude: f.exclude, prefix: path.Join(f.prefix, dir)}
+}
+
+// WithIgnore returns a copy of f that also excludes the paths matched by the
+// lines of an ignore file. Invalid lines are skipped, as git does.
+func (f *Filter) WithIgnore(lines []string) *Filter {
+	extended := &Filter{}
+	if f != nil {
+		extended.include = f.include
+		extended.exclude = f.exclude
+		extended.prefix = f.prefix
+		extended.ignore = append(extended.ignore, f.ignore...)
+	}
+	for _, line := range lines {
+		if p, ok, err := parsePattern(line); ok && err == nil {
+			extended.ignore = append(extended.ignore, p)
+		}
+	}
+	return extended
+}
+
+// SparsePatterns translates the filter to non-cone sparse-checkout patterns
+// for the repository directory dir.
+func (f *Filter) SparsePatterns(dir string) []string {
+	root := "/"
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		root += dir + "/"
+	}
+
+	// Patterns written for a parent folder cannot be rooted in a submodule,
+	// everything is checked out and the copy applies the filter.
+	if f.prefix != "" {
+		return []string{root + "*"}
+	}
+
+	var lines []string
+	if len(f.include) == 0 {
+		lines = append(lines, root+"*")
+	}
+	for _, p := range f.include {
+		lines = append(lines, p.rooted(root, p.negate))
+	}
+	for _, p := range f.exclude {
+		lines = append(lines, p.rooted(root, !p.negate))
+	}
+	return lines
+}
+
+func (p pattern) rooted(root string, negate bool) string {
+	line := root
+	if !p.anchored {
+		line += "**/"
+	}
+	line += p.body
+	if p.dirOnly {
+		line += "/"
+	}
+	if negate {
+		line = "!" + line
+	}
+	return line
+}
@@ -0,0 +1,292 @@
+package pathfilter
+
+import (
+	"slices"
+	"testing"
+)
+
+type matchCase struct {
+	path  string
+	isDir bool
+	want  bool
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+		cases            []matchCase
+	}{
+		{
+			name:    "include by extension at any depth",
+			include: []string{"*.go"},
+			cases: []matchCase{
+				{"main.go", false, true},
+				{"a/b/main.go", false, true},
+				{"README.md", false, false},
+				{"a/b", true, false},
+			},
+		},
+		{
+			name:    "include a directory",
+			include: []string{"docs/"},
+			cases: []matchCase{
+				{"docs", true, true},
+				{"docs/guide/intro.md", false, true},
+				{"src/docs/a.md", false, true},
+				{"docs", false, false},
+				{"src/a.go", false, false},
+			},
+		},
+		{
+			name:    "anchored include",
+			include: []string{"/cmd/*.go"},
+			cases: []matchCase{
+				{"cmd/main.go", false, true},
+				{"tools/cmd/main.go", false, false},
+				{"cmd/sub/main.go", false, false},
+			},
+		},
+		{
+			name:    "double star",
+			include: []string{"src/**/gen/*.go"},
+			cases: []matchCase{
+				{"src/gen/a.go", false, true},
+				{"src/x/y/gen/a.go", false, true},
+				{"lib/gen/a.go", false, false},
+			},
+		},
+		{
+			name:    "exclude",
+			exclude: []string{"**/*_test.go", "testdata/"},
+			cases: []matchCase{
+				{"a/main.go", false, true},
+				{"a/main_test.go", false, false},
+				{"main_test.go", false, false},
+				{"testdata", true, false},
+				{"a/testdata/fixture.json", false, false},
+				{"testdata.go", false, true},
+			},
+		},
+		{
+			name:    "anchored exclude",
+			exclude: []string{"/build/"},
+			cases: []matchCase{
+				{"build/out.bin", false, false},
+				{"src/build/gen.go", false, true},
+			},
+		},
+		{
+			name:    "negated exclude",
+			exclude: []string{"*.md", "!README.md"},
+			cases: []matchCase{
+				{"README.md", false, true},
+				{"docs/README.md", false, true},
+				{"CHANGELOG.md", false, false},
+				{"docs/guide.md", false, false},
+			},
+		},
+		{
+			name:    "negated include",
+			include: []string{"*.go", "!*_test.go"},
+			cases: []matchCase{
+				{"a/main.go", false, true},
+				{"a/main_test.go", false, false},
+			},
+		},
+		{
+			name:    "last matching pattern wins",
+			exclude: []string{"!keep.txt", "*.txt"},
+			cases: []matchCase{
+				{"keep.txt", false, false},
+			},
+		},
+		{
+			name:    "include and exclude",
+			include: []string{"src/"},
+			exclude: []string{"*.gen.go"},
+			cases: []matchCase{
+				{"src/a.go", false, true},
+				{"src/a.gen.go", false, false},
+				{"lib/a.go", false, false},
+			},
+		},
+		{
+			name:    "character classes and wildcards",
+			exclude: []string{"file[0-9].txt", "tmp[!a-z]", "?.log"},
+			cases: []matchCase{
+				{"file1.txt", false, false},
+				{"fileA.txt", false, true},
+				{"tmp1", false, false},
+				{"tmpa", false, true},
+				{"a.log", false, false},
+				{"ab.log", false, true},
+				{"a/b.log", false, false},
+			},
+		},
+		{
+			name:    "escaped special characters",
+			include: []string{`\#notes`, `\!important`, `a\*b`},
+			cases: []matchCase{
+				{"#notes", false, true},
+				{"!important", false, true},
+				{"a*b", false, true},
+				{"axb", false, false},
+			},
+		},
+		{
+			name:    "comments and blank lines",
+			exclude: []string{"# *.go", "", "   ", "*.log"},
+			cases: []matchCase{
+				{"main.go", false, true},
+				{"a.log", false, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			for _, c := range tt.cases {
+				if got := f.Match(c.path, c.isDir); got != c.want {
+					t.Errorf("Match(%q, %v) = %v, want %v", c.path, c.isDir, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	f, err := New(nil, []string{"# only a comment"})
+	if err != nil || f != nil {
+		t.Errorf("New without patterns = %v, %v, want nil, nil", f, err)
+	}
+	if !f.Match("anything", false) || f.Prune("anything") {
+		t.Error("a nil Filter must keep everything")
+	}
+
+	for _, line := range []string{"/", "!", "!/"} {
+		if _, err := New([]string{line}, nil); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestFilterPrune(t *testing.T) {
+	f, err := New([]string{"*.go"}, []string{"vendor/", "/build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"vendor", true},
+		{"a/vendor", true},
+		{"a/vendor/b", true},
+		{"build", true},
+		{"a/build", false},
+		{"src", false},
+	}
+	for _, tt := range tests {
+		if got := f.Prune(tt.dir); got != tt.want {
+			t.Errorf("Prune(%q) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestFilterWithIgnore(t *testing.T) {
+	var f *Filter
+	ignored := f.WithIgnore([]string{"*.log", "!keep.log", "[", "/"})
+	tests := []matchCase{
+		{"a.log", false, false},
+		{"keep.log", false, true},
+		{"main.go", false, true},
+	}
+	for _, c := range tests {
+		if got := ignored.Match(c.path, c.isDir); got != c.want {
+			t.Errorf("Match(%q) = %v, want %v", c.path, got, c.want)
+		}
+	}
+
+	base, err := New(nil, []string{"*.tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	both := base.WithIgnore([]string{"*.log"})
+	if both.Match("a.tmp", false) || both.Match("a.log", false) || !both.Match("a.go", false) {
+		t.Error("WithIgnore must keep the exclude patterns of the filter")
+	}
+	if !base.Match("a.log", false) {
+		t.Error("WithIgnore must not change the original filter")
+	}
+}
+
+func TestFilterBelow(t *testing.T) {
+	f, err := New([]string{"/lib/src/"}, []string{"*_test.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	below := f.WithIgnore([]string{"*.h"}).Below("lib")
+	tests := []matchCase{
+		{"src/a.c", false, true},
+		{"src/a.h", false, true},
+		{"src/a_test.c", false, false},
+		{"a.c", false, false},
+	}
+	for _, c := range tests {
+		if got := below.Match(c.path, c.isDir); got != c.want {
+			t.Errorf("Below(lib).Match(%q) = %v, want %v", c.path, got, c.want)
+		}
+	}
+
+	if got := below.Below("src").Match("a.c", false); !got {
+		t.Error("nested Below must join the prefixes")
+	}
+
+	var empty *Filter
+	if empty.WithIgnore([]string{"*.h"}).Below("lib") != nil {
+		t.Error("Below without include or exclude patterns must return nil")
+	}
+}
+
+func TestSparsePatterns(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+		dir              string
+		want             []string
+	}{
+		{
+			name:    "include and exclude below a folder",
+			include: []string{"*.go", "/cmd/"},
+			exclude: []string{"testdata/", "!keep/"},
+			dir:     "src/",
+			want:    []string{"/src/**/*.go", "/src/cmd/", "!/src/**/testdata/", "/src/**/keep/"},
+		},
+		{
+			name:    "exclude only at the root",
+			exclude: []string{"*.bin"},
+			want:    []string{"/*", "!/**/*.bin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.SparsePatterns(tt.dir); !slices.Equal(got, tt.want) {
+				t.Errorf("SparsePatterns(%q) = %q, want %q", tt.dir, got, tt.want)
+			}
+		})
+	}
+
+	f, _ := New([]string{"*.go"}, nil)
+	if got := f.Below("lib").SparsePatterns("lib"); !slices.Equal(got, []string{"/lib/*"}) {
+		t.Errorf("SparsePatterns below a submodule = %q, want everything", got)
+	}
+}
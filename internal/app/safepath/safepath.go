@@ -0,0 +1,110 @@
+// Package safepath validates the paths a repository, an API listing or an
+// archive supplies before anything is written for them, so that a hostile
+// source cannot place files outside the output directory.
+package safepath
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+// drivePattern matches a Windows drive letter, "C:" or "C:name".
+var drivePattern = regexp.MustCompile(`^[A-Za-z]:`)
+
+// Check validates relPath, a slash separated path relative to an output
+// directory. It refuses "..", absolute paths, and backslashes, drive letters
+// and UNC names, which leave the directory on Windows.
+func Check(relPath string) error {
+	switch {
+	case strings.HasPrefix(relPath, "//"), strings.HasPrefix(relPath, `\\`):
+		return Error(relPath, "UNC names are not allowed")
+	case strings.HasPrefix(relPath, "/"):
+		return Error(relPath, "absolute paths are not allowed")
+	case strings.ContainsRune(relPath, '\\'):
+		return Error(relPath, "backslashes are not allowed")
+	case strings.ContainsRune(relPath, 0):
+		return Error(relPath, "NUL bytes are not allowed")
+	}
+
+	for _, part := range strings.Split(relPath, "/") {
+		if part == ".." {
+			return Error(relPath, "'..' is not allowed")
+		}
+		if drivePattern.MatchString(part) {
+			return Error(relPath, "drive letters are not allowed")
+		}
+	}
+	return nil
+}
+
+// Join checks relPath and returns it below root.
+func Join(root, relPath string) (string, error) {
+	if err := Check(relPath); err != nil {
+		return "", err
+	}
+	return filepath.Join(root, filepath.FromSlash(relPath)), nil
+}
+
+// CheckLink validates a symlink at linkPath, relative to the output directory,
+// pointing at target. The target must stay inside the output directory.
+func CheckLink(linkPath, target string) error {
+	if err := Check(linkPath); err != nil {
+		return err
+	}
+	if target == "" || path.IsAbs(target) || strings.HasPrefix(target, `\\`) ||
+		strings.ContainsRune(target, '\\') || drivePattern.MatchString(target) {
+		return Error(linkPath, fmt.Sprintf("its link target %s is outside the output directory", target))
+	}
+
+	resolved := path.Join(path.Dir(linkPath), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return Error(linkPath, fmt.Sprintf("its link target %s is outside the output directory", target))
+	}
+	return nil
+}
+
+// Names records the paths written by a download and refuses paths that only
+// differ in case from an earlier one, since they overwrite each other on
+// case-insensitive filesystems. It is safe for concurrent use.
+type Names struct {
+	mu   sync.Mutex
+	seen map[string]string
+}
+
+func NewNames() *Names {
+	return &Names{seen: make(map[string]string)}
+}
+
+// Add records p, a filesystem or archive path, and its parent directories.
+func (n *Names) Add(p string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for dir := filepath.Clean(p); ; dir = filepath.Dir(dir) {
+		key := strings.ToLower(dir)
+		previous, ok := n.seen[key]
+		if ok && previous != dir {
+			return Error(filepath.ToSlash(dir),
+				fmt.Sprintf("it only differs in case from %s", filepath.ToSlash(previous)))
+		}
+		if ok || filepath.Dir(dir) == dir {
+			return nil
+		}
+		n.seen[key] = dir
+	}
+}
+
+// Error reports that p is refused for reason.
+func Error(p, reason string) error {
+	return &errors.AppError{
+		Err:     errors.ErrUnsafePath,
+		Message: fmt.Sprintf("Refusing to write '%s': %s", p, reason),
+		Hint:    "The repository contains an entry that cannot be written safely; download a folder that does not include it",
+	}
+}
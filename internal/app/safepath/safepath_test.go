@@ -0,0 +1,110 @@
+package safepath
+
+import (
+	stderrors "errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/dagimg-dot/gitsnip/internal/errors"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"a.txt", true},
+		{"a/b/c.txt", true},
+		{"..a/b..", true},
+		{"a/./b", true},
+		{"ab:c", true},
+		{"..", false},
+		{"../a", false},
+		{"a/../../b", false},
+		{"a/..", false},
+		{"/etc/passwd", false},
+		{"//server/share", false},
+		{`\\server\share`, false},
+		{`a\..\b`, false},
+		{"C:/Windows", false},
+		{"c:name", false},
+		{"a/D:x", false},
+		{"a\x00b", false},
+	}
+	for _, tt := range tests {
+		err := Check(tt.path)
+		if (err == nil) != tt.ok {
+			t.Errorf("Check(%q) = %v, want ok %v", tt.path, err, tt.ok)
+		}
+		if err != nil && !stderrors.Is(err, errors.ErrUnsafePath) {
+			t.Errorf("Check(%q) = %v, want ErrUnsafePath", tt.path, err)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	root := filepath.Join("out", "dir")
+	got, err := Join(root, "a/b.txt")
+	if want := filepath.Join(root, "a", "b.txt"); err != nil || got != want {
+		t.Errorf("Join = %q, %v, want %q", got, err, want)
+	}
+	if _, err := Join(root, "../b.txt"); err == nil {
+		t.Error("Join must refuse paths leaving root")
+	}
+}
+
+func TestCheckLink(t *testing.T) {
+	tests := []struct {
+		link, target string
+		ok           bool
+	}{
+		{"link", "a.txt", true},
+		{"a/link", "b.txt", true},
+		{"a/link", "../b.txt", true},
+		{"a/b/link", "../../c", true},
+		{"a/link", "./b/../c", true},
+		{"a/link", "..", true},
+		{"link", "..", false},
+		{"link", "../outside", false},
+		{"a/link", "../../b.txt", false},
+		{"a/link", "b/../../../c", false},
+		{"link", "/etc/passwd", false},
+		{"link", "", false},
+		{"link", `..\outside`, false},
+		{"link", `\\server\share`, false},
+		{"link", "C:/Windows", false},
+		{"../link", "a.txt", false},
+		{"/link", "a.txt", false},
+	}
+	for _, tt := range tests {
+		err := CheckLink(tt.link, tt.target)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckLink(%q, %q) = %v, want ok %v", tt.link, tt.target, err, tt.ok)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		paths []string
+		ok    bool
+	}{
+		{[]string{"a/b.txt", "a/c.txt", "a/b.txt"}, true},
+		{[]string{"a/b.txt", "a/B.txt"}, false},
+		{[]string{"dir/x", "Dir/y"}, false},
+		{[]string{"a/b/c", "A"}, false},
+		{[]string{"readme", "readme.md"}, true},
+	}
+	for _, tt := range tests {
+		names := NewNames()
+		var err error
+		for _, p := range tt.paths {
+			if err = names.Add(filepath.FromSlash(p)); err != nil {
+				break
+			}
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("Add(%q) = %v, want ok %v", tt.paths, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Package semver parses semantic version tags and npm style version
+// constraints such as "^1.4", "~2.0.x" or ">=1.2 <2".
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version. Build metadata is dropped.
+type Version struct {
+	Major, Minor, Patch int
+	Prerelease          string
+}
+
+var versionPattern = regexp.MustCompile(`^[vV]?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// Parse parses a tag such as "v1.4.2" or "2.0.0-rc.1".
+func Parse(tag string) (Version, bool) {
+	m := versionPattern.FindStringSubmatch(strings.TrimSpace(tag))
+	if m == nil {
+		return Version{}, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	patch, _ := strconv.Atoi(m[3])
+	return Version{Major: major, Minor: minor, Patch: patch, Prerelease: m[4]}, true
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 following semver precedence.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(o.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePrereleaseField(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(a) - len(b))
+}
+
+func comparePrereleaseField(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return sign(an - bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// comparator is a single "<op> <version>" test.
+type comparator struct {
+	op      string
+	version Version
+}
+
+func (c comparator) matches(v Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	default:
+		return cmp == 0
+	}
+}
+
+// Constraint is a set of alternatives ("||"), each a list of comparators
+// that must all match.
+type Constraint struct {
+	alternatives [][]comparator
+	// prerelease versions only match when the constraint names a
+	// prerelease of the same major.minor.patch, as in npm.
+	prereleases []Version
+}
+
+// IsConstraint reports whether ref looks like a version constraint rather
+// than a plain tag, branch or commit name.
+func IsConstraint(ref string) bool {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return false
+	}
+	if strings.ContainsAny(ref[:1], "^~<>=*") || strings.Contains(ref, "||") {
+		return true
+	}
+	_, err := ParseConstraint(ref)
+	return err == nil && hasWildcard(ref)
+}
+
+// IsXRange reports whether ref is a constraint only through x components,
+// as in 1.x. Such names are also valid branch and tag names.
+func IsXRange(ref string) bool {
+	ref = strings.TrimSpace(ref)
+	return IsConstraint(ref) && !strings.ContainsAny(ref, "^~<>=* |")
+}
+
+func hasWildcard(ref string) bool {
+	for _, part := range strings.Split(strings.TrimLeft(ref, "vV"), ".") {
+		if part == "x" || part == "X" || part == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+var partialPattern = regexp.MustCompile(`^[vV]?(\d+|[xX*])(?:\.(\d+|[xX*]))?(?:\.(\d+|[xX*]))?(?:-([0-9A-Za-z.-]+))?$`)
+
+// ParseConstraint parses caret (^1.4), tilde (~2.0.x), x-range (1.x),
+// comparator ranges (>=1.2 <2) and "||" separated constraints.
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{}
+	for _, alt := range strings.Split(s, "||") {
+		var comparators []comparator
+		for _, term := range strings.Fields(strings.ReplaceAll(alt, ",", " ")) {
+			cs, err := c.parseTerm(term)
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, cs...)
+		}
+		if len(comparators) == 0 {
+			return nil, fmt.Errorf("empty version constraint")
+		}
+		c.alternatives = append(c.alternatives, comparators)
+	}
+	return c, nil
+}
+
+func (c *Constraint) parseTerm(term string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, prefix) {
+			op, term = prefix, term[len(prefix):]
+			break
+		}
+	}
+
+	m := partialPattern.FindStringSubmatch(term)
+	if m == nil {
+		return nil, fmt.Errorf("invalid version %q in constraint", term)
+	}
+
+	// Missing and wildcard parts are both "any", parts after the first
+	// wildcard are ignored.
+	parts := make([]int, 0, 3)
+	for _, p := range m[1:4] {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	if len(parts) < 3 && m[4] != "" {
+		return nil, fmt.Errorf("invalid version %q in constraint", term)
+	}
+
+	base := Version{Prerelease: m[4]}
+	fields := []*int{&base.Major, &base.Minor, &base.Patch}
+	for i, n := range parts {
+		*fields[i] = n
+	}
+	if base.Prerelease != "" {
+		c.prereleases = append(c.prereleases, base)
+	}
+
+	// upper returns the first version past the range that keeps the first
+	// n parts fixed.
+	upper := func(n int) Version {
+		switch n {
+		case 0:
+			return Version{Major: 1 << 30}
+		case 1:
+			return Version{Major: base.Major + 1, Prerelease: "0"}
+		case 2:
+			return Version{Major: base.Major, Minor: base.Minor + 1, Prerelease: "0"}
+		default:
+			return Version{Major: base.Major, Minor: base.Minor, Patch: base.Patch + 1, Prerelease: "0"}
+		}
+	}
+	between := func(n int) []comparator {
+		return []comparator{{">=", base}, {"<", upper(n)}}
+	}
+
+	switch op {
+	case "^":
+		// Fix everything up to the first non-zero part.
+		switch {
+		case len(parts) == 0:
+			return between(0), nil
+		case base.Major != 0 || len(parts) == 1:
+			return between(1), nil
+		case base.Minor != 0 || len(parts) == 2:
+			return between(2), nil
+		default:
+			return between(3), nil
+		}
+	case "~":
+		if len(parts) <= 1 {
+			return between(len(parts)), nil
+		}
+		return between(2), nil
+	case "", "=":
+		if len(parts) == 3 {
+			return []comparator{{"=", base}}, nil
+		}
+		return between(len(parts)), nil
+	case ">", "<=":
+		if len(parts) < 3 {
+			// ">1.2" means ">=1.3.0", "<=1.2" means "<1.3.0".
+			flipped := map[string]string{">": ">=", "<=": "<"}[op]
+			return []comparator{{flipped, upper(len(parts))}}, nil
+		}
+		return []comparator{{op, base}}, nil
+	default: // ">=", "<"
+		return []comparator{{op, base}}, nil
+	}
+}
+
+// Matches reports whether v satisfies the constraint.
+func (c *Constraint) Matches(v Version) bool {
+	if v.Prerelease != "" {
+		allowed := false
+		for _, p := range c.prereleases {
+			if p.Major == v.Major && p.Minor == v.Minor && p.Patch == v.Patch {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	for _, comparators := range c.alternatives {
+		ok := true
+		for _, comp := range comparators {
+			if !comp.matches(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Highest returns the tag with the highest version satisfying c. ok is false
+// when no tag matches.
+func (c *Constraint) Highest(tags []string) (tag string, ok bool) {
+	var best Version
+	for _, t := range tags {
+		v, valid := Parse(t)
+		if !valid || !c.Matches(v) {
+			continue
+		}
+		if !ok || v.Compare(best) > 0 {
+			tag, best, ok = t, v, true
+		}
+	}
+	return tag, ok
+}
@@ -0,0 +1,164 @@
+package semver
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	// In increasing order of precedence, from the semver specification.
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := Parse(ordered[i])
+			b, _ := Parse(ordered[j])
+			want := sign(i - j)
+			if got := a.Compare(b); got != want {
+				t.Errorf("Compare(%s, %s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"v1.4.2", "1.4.2", true},
+		{"V1.4.2", "1.4.2", true},
+		{"2.0.0-rc.1", "2.0.0-rc.1", true},
+		{"1.0.0+build.5", "1.0.0", true},
+		{" 1.2.3 ", "1.2.3", true},
+		{"1.2", "", false},
+		{"release-1.2.3", "", false},
+		{"main", "", false},
+	}
+	for _, tt := range tests {
+		v, ok := Parse(tt.tag)
+		if ok != tt.ok {
+			t.Errorf("Parse(%q) ok = %v, want %v", tt.tag, ok, tt.ok)
+			continue
+		}
+		if ok && v.String() != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.tag, v, tt.want)
+		}
+	}
+}
+
+func TestIsConstraint(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want bool
+	}{
+		{"^1.4", true},
+		{"~2.0.x", true},
+		{">=1.2 <2", true},
+		{"1.x", true},
+		{"v2.*", true},
+		{"1.x || 2.x", true},
+		{"*", true},
+		{"v1.2.3", false},
+		{"1.2", false},
+		{"main", false},
+		{"release/1.x", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsConstraint(tt.ref); got != tt.want {
+			t.Errorf("IsConstraint(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestIsXRange(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want bool
+	}{
+		{"2.x", true},
+		{"v1.2.X", true},
+		{"x", true},
+		{"2.*", false},
+		{"^2.x", false},
+		{"1.x || 2.x", false},
+		{"1.2", false},
+		{"main", false},
+	}
+	for _, tt := range tests {
+		if got := IsXRange(tt.ref); got != tt.want {
+			t.Errorf("IsXRange(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestParseConstraintErrors(t *testing.T) {
+	for _, s := range []string{"", "   ", "^abc", "1.x-rc.1", ">=1.2 ||", "1.2.3.4"} {
+		if _, err := ParseConstraint(s); err == nil {
+			t.Errorf("ParseConstraint(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestHighest(t *testing.T) {
+	tags := []string{
+		"0.2.3", "0.2.9", "0.3.0",
+		"v1.0.0", "v1.4.0", "v1.4.2", "v1.5.0-rc.1", "v1.9.9",
+		"v2.0.0", "v2.1.0-beta",
+		"v3.0.0",
+		"latest", "nightly-2024",
+	}
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{"^1.4", "v1.9.9"},
+		{"^1.4.1", "v1.9.9"},
+		{"~1.4", "v1.4.2"},
+		{"~1.4.0", "v1.4.2"},
+		{"~1", "v1.9.9"},
+		{"1.x", "v1.9.9"},
+		{"1.4.x", "v1.4.2"},
+		{"1.4", "v1.4.2"},
+		{"1.4.0", "v1.4.0"},
+		{"=1.4.0", "v1.4.0"},
+		{"^0.2.3", "0.2.9"},
+		{"^0.2", "0.2.9"},
+		{"^0", "0.3.0"},
+		{">=1.2 <2", "v1.9.9"},
+		{">=1.2, <2", "v1.9.9"},
+		{"<1.4.1", "v1.4.0"},
+		{"<=1.4", "v1.4.2"},
+		{">1.9", "v3.0.0"},
+		{">1.9.9 <3", "v2.0.0"},
+		{"1.x || >=3", "v3.0.0"},
+		{"*", "v3.0.0"},
+		// Prereleases only match constraints naming the same version.
+		{"^2", "v2.0.0"},
+		{">=1.5.0-rc.0 <1.5.0", "v1.5.0-rc.1"},
+		{"^4", ""},
+		{"<0.2.3", ""},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %v", tt.constraint, err)
+			continue
+		}
+		got, ok := c.Highest(tags)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("Highest(%q) = %q, %v, want %q", tt.constraint, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package weburl turns the URLs shown in a browser when viewing a folder or
+// file (".../tree/<ref>/<path>") into a repository URL, ref and path.
+package weburl
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/gitproto"
+	"github.com/dagimg-dot/gitsnip/internal/app/gitutil"
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+)
+
+// Location is what a browser URL points at. Ref and Path are only split
+// correctly after Resolve, since refs may contain slashes.
+type Location struct {
+	RepoURL  string
+	Provider model.ProviderType
+	Ref      string
+	Path     string
+	// IsFile is set for /blob/ URLs, whose path cannot be empty.
+	IsFile bool
+
+	// rest is "<ref>/<path>" as it appeared in the URL.
+	rest string
+	// refKind limits the ref to "heads", "tags" or "commit" when the URL
+	// says which one it is (Gitea).
+	refKind string
+}
+
+var (
+	// https://github.com/owner/repo/tree/<ref>/<path>
+	gitHubPattern = regexp.MustCompile(`^(https?://[^/]+/[^/]+/[^/]+?)(?:\.git)?/(tree|blob)/(.+)$`)
+	// https://gitlab.com/group/subgroup/project/-/tree/<ref>/<path>
+	gitLabPattern = regexp.MustCompile(`^(https?://[^/]+/.+?)(?:\.git)?/-/(tree|blob)/(.+)$`)
+	// https://gitea.com/owner/repo/src/branch/<ref>/<path>
+	giteaPattern = regexp.MustCompile(`^(https?://[^/]+/.+?)(?:\.git)?/src/(branch|tag|commit)/(.+)$`)
+)
+
+var commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// Parse recognises a browser URL of GitHub, GitLab or Gitea. ok is false for
+// anything else, including plain repository URLs.
+func Parse(rawURL string) (loc *Location, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+	u.RawQuery, u.Fragment = "", ""
+	trimmed := strings.TrimSuffix(u.String(), "/")
+
+	if m := gitLabPattern.FindStringSubmatch(trimmed); m != nil {
+		return newLocation(m, model.ProviderTypeGitLab, m[2] == "blob", ""), true
+	}
+	if m := giteaPattern.FindStringSubmatch(trimmed); m != nil {
+		kind := map[string]string{"branch": "heads", "tag": "tags", "commit": "commit"}[m[2]]
+		return newLocation(m, model.ProviderTypeGitea, false, kind), true
+	}
+	if m := gitHubPattern.FindStringSubmatch(trimmed); m != nil {
+		return newLocation(m, "", m[2] == "blob", ""), true
+	}
+	return nil, false
+}
+
+func newLocation(m []string, provider model.ProviderType, isFile bool, refKind string) *Location {
+	rest, err := url.PathUnescape(m[3])
+	if err != nil {
+		rest = m[3]
+	}
+	loc := &Location{
+		RepoURL:  m[1],
+		Provider: provider,
+		IsFile:   isFile,
+		rest:     rest,
+		refKind:  refKind,
+	}
+	loc.Ref, loc.Path, _ = strings.Cut(rest, "/")
+	return loc
+}
+
+// Resolve splits the ref from the path using the refs of the remote: the
+// longest branch or tag that prefixes the URL wins, leaving a path for a
+// file. Commit hashes need no lookup. Without a matching ref the first
+// segment is taken as the ref.
+func (l *Location) Resolve(ctx context.Context, token string) error {
+	first, _, _ := strings.Cut(l.rest, "/")
+	if l.refKind == "commit" || commitPattern.MatchString(first) || !strings.Contains(l.rest, "/") {
+		return nil
+	}
+
+	kinds := []string{"heads", "tags"}
+	if l.refKind != "" {
+		kinds = []string{l.refKind}
+	}
+	var prefixes []string
+	for _, kind := range kinds {
+		prefixes = append(prefixes, "refs/"+kind+"/"+first)
+	}
+
+	client := gitproto.NewClient(gitutil.AuthenticatedURL(l.RepoURL+".git", token, l.Provider))
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+	refs, err := client.LsRefs(ctx, prefixes...)
+	if err != nil {
+		return err
+	}
+
+	best := ""
+	for _, ref := range refs {
+		for _, kind := range kinds {
+			name, ok := strings.CutPrefix(ref.Name, "refs/"+kind+"/")
+			if !ok || len(name) <= len(best) {
+				continue
+			}
+			if (l.rest == name && !l.IsFile) || strings.HasPrefix(l.rest, name+"/") {
+				best = name
+			}
+		}
+	}
+
+	if best != "" {
+		l.Ref = best
+		l.Path = strings.TrimPrefix(strings.TrimPrefix(l.rest, best), "/")
+	}
+	return nil
+}
+
+// SplitWithRef splits the URL using a ref the user named explicitly. ok is
+// false when the URL does not start with ref.
+func (l *Location) SplitWithRef(ref string) (ok bool) {
+	if l.rest != ref && !strings.HasPrefix(l.rest, ref+"/") {
+		return false
+	}
+	l.Ref = ref
+	l.Path = strings.TrimPrefix(strings.TrimPrefix(l.rest, ref), "/")
+	return true
+}
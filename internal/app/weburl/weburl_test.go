@@ -0,0 +1,212 @@
+package weburl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dagimg-dot/gitsnip/internal/app/model"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		url  string
+		want *Location
+	}{
+		{
+			url:  "https://github.com/owner/repo/tree/main/docs/guide",
+			want: &Location{RepoURL: "https://github.com/owner/repo", Ref: "main", Path: "docs/guide"},
+		},
+		{
+			url:  "https://github.com/owner/repo/blob/v1.2.0/README.md",
+			want: &Location{RepoURL: "https://github.com/owner/repo", Ref: "v1.2.0", Path: "README.md", IsFile: true},
+		},
+		{
+			url:  "https://github.com/owner/repo.git/tree/main/",
+			want: &Location{RepoURL: "https://github.com/owner/repo", Ref: "main"},
+		},
+		{
+			url:  "https://github.com/owner/repo/tree/main/docs?plain=1#readme",
+			want: &Location{RepoURL: "https://github.com/owner/repo", Ref: "main", Path: "docs"},
+		},
+		{
+			url:  "https://github.com/owner/repo/tree/feature%2Fx/docs",
+			want: &Location{RepoURL: "https://github.com/owner/repo", Ref: "feature", Path: "x/docs"},
+		},
+		{
+			url: "https://gitlab.com/group/sub/project/-/blob/release/2.x/src/main.c",
+			want: &Location{RepoURL: "https://gitlab.com/group/sub/project", Provider: model.ProviderTypeGitLab,
+				Ref: "release", Path: "2.x/src/main.c", IsFile: true},
+		},
+		{
+			url: "https://codeberg.org/owner/repo/src/branch/main/lib",
+			want: &Location{RepoURL: "https://codeberg.org/owner/repo", Provider: model.ProviderTypeGitea,
+				Ref: "main", Path: "lib"},
+		},
+		{url: "https://github.com/owner/repo", want: nil},
+		{url: "https://github.com/owner/repo/issues/1", want: nil},
+		{url: "github.com/owner/repo/tree/main", want: nil},
+		{url: "::not a url", want: nil},
+	}
+
+	for _, tt := range tests {
+		loc, ok := Parse(tt.url)
+		if ok != (tt.want != nil) {
+			t.Errorf("Parse(%q) ok = %v, want %v", tt.url, ok, tt.want != nil)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		got := Location{RepoURL: loc.RepoURL, Provider: loc.Provider, Ref: loc.Ref, Path: loc.Path, IsFile: loc.IsFile}
+		if got != *tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.url, got, *tt.want)
+		}
+	}
+}
+
+func TestSplitWithRef(t *testing.T) {
+	const url = "https://github.com/owner/repo/tree/release/2.x/docs/api"
+	tests := []struct {
+		ref      string
+		ok       bool
+		wantPath string
+	}{
+		{"release/2.x", true, "docs/api"},
+		{"release", true, "2.x/docs/api"},
+		{"release/2.x/docs/api", true, ""},
+		{"release/2", false, ""},
+		{"release/2.x/docs/api/more", false, ""},
+		{"main", false, ""},
+	}
+	for _, tt := range tests {
+		loc, _ := Parse(url)
+		ok := loc.SplitWithRef(tt.ref)
+		if ok != tt.ok {
+			t.Errorf("SplitWithRef(%q) = %v, want %v", tt.ref, ok, tt.ok)
+			continue
+		}
+		if ok && (loc.Ref != tt.ref || loc.Path != tt.wantPath) {
+			t.Errorf("SplitWithRef(%q) gave ref %q path %q, want path %q", tt.ref, loc.Ref, loc.Path, tt.wantPath)
+		}
+	}
+}
+
+// pkt encodes lines as pkt-lines followed by a flush packet.
+func pkt(lines ...string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&b, "%04x%s\n", len(line)+5, line)
+	}
+	return b.String() + "0000"
+}
+
+// newRefServer serves the refs of owner/repo over protocol version 2. It
+// answers ls-refs with the refs matching the requested prefixes.
+func newRefServer(t *testing.T, refs []string, requests *int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		switch r.URL.Path {
+		case "/owner/repo.git/info/refs":
+			io.WriteString(w, pkt("# service=git-upload-pack"))
+			io.WriteString(w, pkt("version 2", "ls-refs"))
+		case "/owner/repo.git/git-upload-pack":
+			body, _ := io.ReadAll(r.Body)
+			var lines []string
+			for _, ref := range refs {
+				for _, arg := range strings.Split(string(body), "\n") {
+					i := strings.Index(arg, "ref-prefix ")
+					if i >= 0 && strings.HasPrefix(ref, arg[i+len("ref-prefix "):]) {
+						lines = append(lines, "0123456789012345678901234567890123456789 "+ref)
+						break
+					}
+				}
+			}
+			io.WriteString(w, pkt(lines...))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestResolve(t *testing.T) {
+	refs := []string{
+		"refs/heads/main",
+		"refs/heads/release/2",
+		"refs/heads/release/2.x",
+		"refs/heads/feature/login/v2",
+		"refs/tags/v1",
+		"refs/heads/v1/next",
+		"refs/tags/release/2.x/docs",
+	}
+	tests := []struct {
+		rest     string
+		kind     string
+		wantRef  string
+		wantPath string
+		offline  bool
+	}{
+		{rest: "main/docs", wantRef: "main", wantPath: "docs"},
+		{rest: "release/2.x/src/lib", wantRef: "release/2.x", wantPath: "src/lib"},
+		{rest: "release/2/src", wantRef: "release/2", wantPath: "src"},
+		{rest: "release/2.x", wantRef: "release/2.x", wantPath: ""},
+		// A file URL needs a path after the ref.
+		{rest: "release/2.x", kind: "blob", wantRef: "release", wantPath: "2.x"},
+		{rest: "feature/login/v2/app/main.go", wantRef: "feature/login/v2", wantPath: "app/main.go"},
+		// The longest ref wins, tags included.
+		{rest: "release/2.x/docs/api", wantRef: "release/2.x/docs", wantPath: "api"},
+		// Without a matching ref the first segment is the ref.
+		{rest: "unknown/branch/docs", wantRef: "unknown", wantPath: "branch/docs"},
+		// A Gitea tag URL only considers tags.
+		{rest: "v1/next/docs", kind: "tag", wantRef: "v1", wantPath: "next/docs"},
+		{rest: "v1/next/docs", kind: "branch", wantRef: "v1/next", wantPath: "docs"},
+		// Commits and single segments need no lookup.
+		{rest: "0123456789abcdef0123456789abcdef01234567/docs", wantRef: "0123456789abcdef0123456789abcdef01234567", wantPath: "docs", offline: true},
+		{rest: "main", wantRef: "main", offline: true},
+		{rest: "release/2.x/docs", kind: "commit", wantRef: "release", wantPath: "2.x/docs", offline: true},
+	}
+
+	for _, tt := range tests {
+		requests := 0
+		srv := newRefServer(t, refs, &requests)
+
+		rawURL := srv.URL + "/owner/repo/tree/" + tt.rest
+		if tt.kind == "blob" {
+			rawURL = srv.URL + "/owner/repo/blob/" + tt.rest
+		} else if tt.kind != "" {
+			rawURL = srv.URL + "/owner/repo/src/" + tt.kind + "/" + tt.rest
+		}
+		loc, ok := Parse(rawURL)
+		if !ok {
+			t.Fatalf("Parse(%q) failed", rawURL)
+		}
+		if err := loc.Resolve(context.Background(), ""); err != nil {
+			t.Errorf("Resolve(%q): %v", tt.rest, err)
+			continue
+		}
+		if loc.Ref != tt.wantRef || loc.Path != tt.wantPath {
+			t.Errorf("Resolve(%q) = ref %q path %q, want ref %q path %q",
+				tt.rest, loc.Ref, loc.Path, tt.wantRef, tt.wantPath)
+		}
+		if tt.offline && requests != 0 {
+			t.Errorf("Resolve(%q) made %d requests, want none", tt.rest, requests)
+		}
+	}
+}
+
+func TestResolveError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	loc, _ := Parse(srv.URL + "/owner/repo/tree/release/2.x/docs")
+	if err := loc.Resolve(context.Background(), ""); err == nil {
+		t.Error("Resolve succeeded against a server without the repository")
+	}
+}
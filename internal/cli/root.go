@@ -1,35 +1,80 @@
 package cli
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/dagimg-dot/gitsnip/internal/app"
+	"github.com/dagimg-dot/gitsnip/internal/app/gitutil"
 	"github.com/dagimg-dot/gitsnip/internal/app/model"
+	appoutput "github.com/dagimg-dot/gitsnip/internal/app/output"
+	"github.com/dagimg-dot/gitsnip/internal/app/pathfilter"
+	"github.com/dagimg-dot/gitsnip/internal/app/weburl"
 	apperrors "github.com/dagimg-dot/gitsnip/internal/errors"
 	"github.com/spf13/cobra"
 )
 
 var (
 	branch   string
+	ref      string
 	method   string
 	token    string
 	provider string
+	apiURL   string
 	quiet    bool
 
+	concurrency int
+	timeout     time.Duration
+
+	output       string
+	outputFormat string
+	onConflict   string
+	extraPaths   []string
+
+	includes []string
+	excludes []string
+
+	noUpstreamIgnore bool
+	symlinks         string
+
+	recurseSubmodules bool
+
 	rootCmd = &cobra.Command{
-		Use:   "gitsnip <repository_url> <folder_path> [output_dir]",
-		Short: "Download a specific folder from a Git repository (GitHub)",
+		Use:   "gitsnip <repository_url> <folder_path> [output_dir] | gitsnip <folder_url> [output_dir]",
+		Short: "Download a specific folder from a Git repository (GitHub, GitLab, Gitea, Bitbucket, Azure DevOps)",
 		Long: `Gitsnip allows you to download a specific folder from a remote Git
 repository without cloning the entire repository.
 
 Arguments:
-  repository_url: URL of the GitHub repository (e.g., https://github.com/user/repo)
+  repository_url: URL of the repository (e.g., https://github.com/user/repo)
   folder_path:    Path to the folder within the repository you want to download.
+                  A path to a single file downloads just that file.
   output_dir:     Optional. Directory where the folder should be saved.
-                  Defaults to the folder's base name in the current directory.`,
+                  Defaults to the folder's base name in the current directory.
+                  A single file is saved as output_dir, or inside it when it
+                  is an existing directory or ends with a slash.
+
+Instead of repository_url and folder_path, the URL of the folder as shown in
+the browser may be given, e.g. https://github.com/user/repo/tree/main/docs.
+Branch and folder are then taken from the URL.
+
+Several folders are fetched in one go by passing further folder_path
+arguments together with --output, or with --path. Each is saved under its
+base name inside the output directory.
+
+With --output-format tar.gz or zip, or an output name ending in .tar.gz,
+.tgz or .zip, the download is written to that archive instead. A single
+folder forms the root of the archive, several folders are each stored under
+their base name.
+
+An output of "-" writes a tar stream to stdout, or the raw content when a
+single file is downloaded. Progress is always reported on stderr.`,
 
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
@@ -38,64 +83,188 @@ Arguments:
 			}
 			return nil
 		},
-		Args: cobra.RangeArgs(0, 3),
+		Args: cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
 				return nil
 			}
 
-			if len(args) < 2 {
-				return fmt.Errorf("requires at least repository_url and folder_path arguments")
+			if ref != "" && branch != "" {
+				return fmt.Errorf("--branch and --ref cannot be used together")
 			}
 
 			repoURL := args[0]
-			folderPath := args[1]
-			outputDir := "" // default
+			rest := args[1:]
+			var folderPaths []string
+			outputDir := output
 
-			if len(args) == 3 {
-				outputDir = args[2]
+			// Without --output and --path the argument after the folder is
+			// the output_dir, otherwise every argument is a folder.
+			positionalOutput := !cmd.Flags().Changed("output") && len(extraPaths) == 0
+
+			if loc, ok := weburl.Parse(repoURL); ok {
+				if positionalOutput && len(rest) > 1 {
+					return fmt.Errorf("a folder URL takes at most one more argument, the output_dir")
+				}
+				if err := resolveWebURL(cmd, loc); err != nil {
+					return err
+				}
+
+				repoURL = loc.RepoURL
+				folderPaths = []string{loc.Path}
+				if provider == "" && loc.Provider != "" {
+					provider = string(loc.Provider)
+				}
+
+				if positionalOutput && len(rest) == 1 {
+					outputDir = rest[0]
+				} else {
+					folderPaths = append(folderPaths, rest...)
+				}
 			} else {
-				outputDir = filepath.Base(folderPath)
+				if len(rest) == 0 && len(extraPaths) == 0 {
+					return fmt.Errorf("requires at least repository_url and folder_path arguments")
+				}
+				if positionalOutput && len(rest) > 2 {
+					return fmt.Errorf("several folder paths need --output for the output directory")
+				}
+
+				if positionalOutput && len(rest) == 2 {
+					outputDir = rest[1]
+					rest = rest[:1]
+				}
+				folderPaths = rest
 			}
+			folderPaths = append(folderPaths, extraPaths...)
 
-			if provider == "" {
-				if strings.Contains(repoURL, "github.com") {
-					provider = "github"
-				} else {
-					provider = "github"
+			format := appoutput.FormatFromName(outputDir)
+			if outputDir == appoutput.Stdout {
+				format = appoutput.FormatTar
+			}
+			if outputFormat != "" {
+				var err error
+				if format, err = appoutput.ParseFormat(outputFormat); err != nil {
+					return err
+				}
+				if format == appoutput.FormatDir && outputDir == appoutput.Stdout {
+					return fmt.Errorf("a directory cannot be written to stdout, use --output-format tar, tar.gz or zip")
+				}
+			}
+
+			// Inside an archive the folders are stored relative to its root.
+			outputFile := ""
+			if format != appoutput.FormatDir {
+				outputFile = outputDir
+				if outputFile == "" {
+					name := defaultName(repoURL, "")
+					if len(folderPaths) == 1 {
+						name = defaultName(repoURL, folderPaths[0])
+					}
+					outputFile = name + "." + string(format)
 				}
+				outputDir = "."
+			}
+
+			targets, err := outputTargets(repoURL, folderPaths, outputDir)
+			if err != nil {
+				return err
+			}
+
+			conflict, err := appoutput.ParseConflict(onConflict)
+			if err != nil {
+				return err
+			}
+
+			symlinkPolicy, err := appoutput.ParseSymlinks(symlinks)
+			if err != nil {
+				return err
+			}
+
+			if provider == "" {
+				provider = string(gitutil.DetectProvider(repoURL))
 			}
 
 			methodType := model.MethodTypeSparse
-			if method == "api" {
+			switch method {
+			case "api":
 				methodType = model.MethodTypeAPI
+			case "archive":
+				methodType = model.MethodTypeArchive
+			}
+
+			providerType, err := parseProvider(provider)
+			if err != nil {
+				return err
 			}
 
-			providerType := model.ProviderTypeGitHub
-			// TODO: add other providers when supported
+			if concurrency < 1 {
+				return fmt.Errorf("--concurrency must be at least 1")
+			}
+			if timeout <= 0 {
+				return fmt.Errorf("--timeout must be positive")
+			}
+
+			filter, err := pathfilter.New(includes, excludes)
+			if err != nil {
+				return fmt.Errorf("invalid --include or --exclude: %w", err)
+			}
 
 			opts := model.DownloadOptions{
-				RepoURL:   repoURL,
-				Subdir:    folderPath,
-				OutputDir: outputDir,
-				Branch:    branch,
-				Token:     token,
-				Method:    methodType,
-				Provider:  providerType,
-				Quiet:     quiet,
+				RepoURL:     repoURL,
+				Subdir:      targets[0].Subdir,
+				OutputDir:   targets[0].OutputDir,
+				Branch:      branch,
+				Token:       token,
+				APIURL:      apiURL,
+				Method:      methodType,
+				Provider:    providerType,
+				Quiet:       quiet,
+				Concurrency: concurrency,
+				Timeout:     timeout,
+				Ref:         ref,
+				Filter:      filter,
+
+				NoUpstreamIgnore:  noUpstreamIgnore,
+				Symlinks:          symlinkPolicy,
+				RecurseSubmodules: recurseSubmodules,
+
+				OutputFormat: format,
+				OutputFile:   outputFile,
+				OnConflict:   conflict,
+			}
+			if len(targets) > 1 {
+				opts.Paths = targets
 			}
 
 			if !quiet {
-				fmt.Printf("Repository URL: %s\n", repoURL)
-				fmt.Printf("Folder Path:    %s\n", folderPath)
-				fmt.Printf("Target Branch:  %s\n", branch)
-				fmt.Printf("Download Method: %s\n", method)
-				fmt.Printf("Output Dir:     %s\n", outputDir)
-				fmt.Printf("Provider:       %s\n", provider)
-				fmt.Println("--------------------------------")
+				fmt.Fprintf(os.Stderr, "Repository URL: %s\n", repoURL)
+				if len(targets) == 1 {
+					fmt.Fprintf(os.Stderr, "Folder Path:    %s\n", targets[0].Subdir)
+				} else {
+					for _, target := range targets {
+						fmt.Fprintf(os.Stderr, "Folder Path:    %s -> %s\n", target.Subdir, target.OutputDir)
+					}
+				}
+				if ref != "" {
+					fmt.Fprintf(os.Stderr, "Target Ref:     %s\n", ref)
+				} else if branch == "" {
+					fmt.Fprintf(os.Stderr, "Target Branch:  (default)\n")
+				} else {
+					fmt.Fprintf(os.Stderr, "Target Branch:  %s\n", branch)
+				}
+				fmt.Fprintf(os.Stderr, "Download Method: %s\n", method)
+				if outputFile == appoutput.Stdout {
+					fmt.Fprintf(os.Stderr, "Output File:    (stdout)\n")
+				} else if outputFile != "" {
+					fmt.Fprintf(os.Stderr, "Output File:    %s\n", outputFile)
+				} else if len(targets) == 1 {
+					fmt.Fprintf(os.Stderr, "Output Dir:     %s\n", targets[0].OutputDir)
+				}
+				fmt.Fprintf(os.Stderr, "Provider:       %s\n", provider)
+				fmt.Fprintln(os.Stderr, "--------------------------------")
 			}
 
-			err := app.Download(opts)
+			err = app.Download(opts)
 
 			var appErr *apperrors.AppError
 			if errors.As(err, &appErr) {
@@ -107,6 +276,92 @@ Arguments:
 	}
 )
 
+// outputTargets decides where each folder is saved. A single folder is saved
+// as outputDir, by default its base name, several folders are each saved
+// under their base name inside outputDir.
+func outputTargets(repoURL string, folderPaths []string, outputDir string) ([]model.PathSpec, error) {
+	if len(folderPaths) == 1 {
+		if outputDir == "" {
+			outputDir = defaultName(repoURL, folderPaths[0])
+		}
+		return []model.PathSpec{{Subdir: folderPaths[0], OutputDir: outputDir}}, nil
+	}
+
+	targets := make([]model.PathSpec, 0, len(folderPaths))
+	savedTo := make(map[string]string)
+	for _, folderPath := range folderPaths {
+		target := filepath.Join(outputDir, defaultName(repoURL, folderPath))
+		if other, ok := savedTo[target]; ok {
+			return nil, fmt.Errorf("'%s' and '%s' would both be saved to %s, download them separately", other, folderPath, target)
+		}
+		savedTo[target] = folderPath
+		targets = append(targets, model.PathSpec{Subdir: folderPath, OutputDir: target})
+	}
+	return targets, nil
+}
+
+// defaultName is the base name of folderPath, or of the repository for its
+// root.
+func defaultName(repoURL, folderPath string) string {
+	if trimmed := strings.Trim(folderPath, "/"); trimmed != "" {
+		return path.Base(trimmed)
+	}
+	return path.Base(strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git"))
+}
+
+// resolveWebURL splits the ref and path of a folder or file URL. An
+// explicit --branch or --ref decides the split, otherwise the remote's refs
+// are consulted.
+func resolveWebURL(cmd *cobra.Command, loc *weburl.Location) error {
+	if cmd.Flags().Changed("branch") {
+		if !loc.SplitWithRef(branch) {
+			return fmt.Errorf("the URL does not point into branch '%s'", branch)
+		}
+		return checkFileURL(loc)
+	}
+	if cmd.Flags().Changed("ref") {
+		if !loc.SplitWithRef(ref) {
+			return fmt.Errorf("the URL does not point into ref '%s'", ref)
+		}
+		return checkFileURL(loc)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := loc.Resolve(ctx, token); err != nil && !quiet {
+		fmt.Fprintf(os.Stderr, "Warning: could not list remote refs (%v), assuming branch '%s'\n", err, loc.Ref)
+	}
+	branch = loc.Ref
+	return checkFileURL(loc)
+}
+
+// checkFileURL rejects a file URL whose ref leaves no path, which would
+// download the whole tree instead of the file.
+func checkFileURL(loc *weburl.Location) error {
+	if loc.IsFile && loc.Path == "" {
+		return fmt.Errorf("the file URL names no file after ref '%s'", loc.Ref)
+	}
+	return nil
+}
+
+func parseProvider(name string) (model.ProviderType, error) {
+	switch strings.ToLower(name) {
+	case "github":
+		return model.ProviderTypeGitHub, nil
+	case "gitlab":
+		return model.ProviderTypeGitLab, nil
+	case "gitea", "forgejo", "codeberg":
+		return model.ProviderTypeGitea, nil
+	case "bitbucket":
+		return model.ProviderTypeBitbucket, nil
+	case "azure", "azuredevops":
+		return model.ProviderTypeAzureDevOps, nil
+	default:
+		return "", fmt.Errorf("unsupported provider: %s", name)
+	}
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main().
 func Execute() error {
@@ -118,9 +373,22 @@ func Execute() error {
 // init is called by Go before main()
 func init() {
 	// TODO: use PersistentFlags if i want flags to be available to subcommands as well
-	rootCmd.Flags().StringVarP(&branch, "branch", "b", "main", "Repository branch to download from")
-	rootCmd.Flags().StringVarP(&method, "method", "m", "sparse", "Download method ('api' or 'sparse')")
-	rootCmd.Flags().StringVarP(&token, "token", "t", "", "GitHub API token for private repositories or increased rate limits")
-	rootCmd.Flags().StringVarP(&provider, "provider", "p", "", "Repository provider ('github', more to come)")
+	rootCmd.Flags().StringVarP(&branch, "branch", "b", "", "Repository branch to download from (defaults to the repository's default branch)")
+	rootCmd.Flags().StringVar(&ref, "ref", "", "Tag, commit SHA (full or abbreviated), ref such as refs/pull/1/head or semver constraint such as '^1.4' to download at")
+	rootCmd.Flags().StringVarP(&method, "method", "m", "sparse", "Download method ('api', 'archive' or 'sparse')")
+	rootCmd.Flags().StringVarP(&token, "token", "t", "", "API token for private repositories or increased rate limits (Bitbucket app passwords as 'username:app_password')")
+	rootCmd.Flags().StringVarP(&provider, "provider", "p", "", "Repository provider ('github', 'gitlab', 'gitea', 'bitbucket' or 'azure'), detected from the URL if omitted")
+	rootCmd.Flags().StringVar(&apiURL, "api-url", "", "API base URL, e.g. https://ghe.example.com/api/v3 (derived from the repository host if omitted)")
+	rootCmd.Flags().IntVarP(&concurrency, "concurrency", "c", model.DefaultConcurrency, "Number of files downloaded in parallel by the API method")
+	rootCmd.Flags().DurationVar(&timeout, "timeout", model.DefaultTimeout, "Time limit for the git operations of a download, such as fetching or listing refs")
 	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Suppress progress output during download")
+	rootCmd.Flags().StringVarP(&output, "output", "o", "", "Output directory, several folders are each saved inside it (replaces the output_dir argument), '-' for stdout")
+	rootCmd.Flags().StringVar(&outputFormat, "output-format", "", "Write the download as 'dir', 'tar', 'tar.gz' or 'zip' (inferred from the output name if omitted)")
+	rootCmd.Flags().StringVar(&onConflict, "on-conflict", string(appoutput.ConflictFail), "What to do when the output is not empty: 'fail', 'overwrite', 'skip', 'clean' (mirror the download, removing other files) or 'merge' (only add missing files)")
+	rootCmd.Flags().StringArrayVar(&extraPaths, "path", nil, "Additional folder path to download, may be repeated")
+	rootCmd.Flags().StringArrayVar(&includes, "include", nil, "Only download files matching this gitignore style pattern, may be repeated")
+	rootCmd.Flags().StringArrayVar(&excludes, "exclude", nil, "Skip files matching this gitignore style pattern, e.g. '**/*_test.go' or 'testdata/', may be repeated")
+	rootCmd.Flags().BoolVar(&noUpstreamIgnore, "no-upstream-ignore", false, "Do not apply the .gitsnipignore file published in the downloaded folder")
+	rootCmd.Flags().StringVar(&symlinks, "symlinks", string(appoutput.SymlinksPreserve), "How to write symbolic links: 'preserve' as links, 'follow' (write what they point to) or 'skip'")
+	rootCmd.Flags().BoolVar(&recurseSubmodules, "recurse-submodules", false, "Also download the submodules inside the folder at the commit the repository records")
 }
@@ -7,7 +7,7 @@ import (
 )
 
 var (
-	ErrRateLimitExceeded      = errors.New("GitHub API rate limit exceeded")
+	ErrRateLimitExceeded      = errors.New("API rate limit exceeded")
 	ErrAuthenticationRequired = errors.New("authentication required for this repository")
 	ErrRepositoryNotFound     = errors.New("repository not found")
 	ErrPathNotFound           = errors.New("path not found in repository")
@@ -19,6 +19,10 @@ var (
 	ErrGitFetchFailed         = errors.New("git fetch failed")
 	ErrGitCheckoutFailed      = errors.New("git checkout failed")
 	ErrGitInvalidRepository   = errors.New("invalid git repository")
+	ErrInvalidRef             = errors.New("invalid reference")
+	ErrOutputExists           = errors.New("output already exists")
+	ErrUnsafePath             = errors.New("unsafe path")
+	ErrUnsupportedMethod      = errors.New("unsupported download method")
 )
 
 type AppError struct {
@@ -53,6 +57,28 @@ func FormatError(err error) string {
 }
 
 func ParseGitHubAPIError(statusCode int, body string) error {
+	return ParseProviderAPIError("GitHub", statusCode, body)
+}
+
+func ParseGitLabAPIError(statusCode int, body string) error {
+	return ParseProviderAPIError("GitLab", statusCode, body)
+}
+
+func ParseGiteaAPIError(statusCode int, body string) error {
+	return ParseProviderAPIError("Gitea", statusCode, body)
+}
+
+func ParseBitbucketAPIError(statusCode int, body string) error {
+	return ParseProviderAPIError("Bitbucket", statusCode, body)
+}
+
+func ParseAzureDevOpsAPIError(statusCode int, body string) error {
+	return ParseProviderAPIError("Azure DevOps", statusCode, body)
+}
+
+// ParseProviderAPIError maps an HTTP error response from a hosting provider's
+// API to an AppError. provider is the human readable name used in messages.
+func ParseProviderAPIError(provider string, statusCode int, body string) error {
 	loweredBody := strings.ToLower(body)
 
 	var appErr AppError
@@ -62,13 +88,13 @@ func ParseGitHubAPIError(statusCode int, body string) error {
 	case 401:
 		appErr.Err = ErrAuthenticationRequired
 		appErr.Message = "Authentication required to access this repository"
-		appErr.Hint = "Use --token flag to provide a GitHub token with appropriate permissions"
+		appErr.Hint = fmt.Sprintf("Use --token flag to provide a %s token with appropriate permissions", provider)
 
 	case 403:
 		if strings.Contains(loweredBody, "rate limit exceeded") {
 			appErr.Err = ErrRateLimitExceeded
-			appErr.Message = "GitHub API rate limit exceeded"
-			appErr.Hint = "Use --token flag to provide a GitHub token to increase rate limits"
+			appErr.Message = fmt.Sprintf("%s API rate limit exceeded", provider)
+			appErr.Hint = fmt.Sprintf("Use --token flag to provide a %s token to increase rate limits", provider)
 		} else {
 			appErr.Err = ErrAuthenticationRequired
 			appErr.Message = "Access forbidden to this repository or resource"
@@ -86,9 +112,14 @@ func ParseGitHubAPIError(statusCode int, body string) error {
 			appErr.Hint = "Check that the folder path exists in the specified branch"
 		}
 
+	case 429:
+		appErr.Err = ErrRateLimitExceeded
+		appErr.Message = fmt.Sprintf("%s API rate limit exceeded", provider)
+		appErr.Hint = fmt.Sprintf("Use --token flag to provide a %s token to increase rate limits", provider)
+
 	default:
 		appErr.Err = errors.New(body)
-		appErr.Message = fmt.Sprintf("GitHub API error (%d): %s", statusCode, body)
+		appErr.Message = fmt.Sprintf("%s API error (%d): %s", provider, statusCode, body)
 	}
 
 	return &appErr
@@ -135,3 +166,31 @@ func ParseGitError(err error, stderr string) error {
 
 	return &appErr
 }
+
+// ParseGitHTTPError maps an HTTP error from a git smart HTTP server to an
+// AppError.
+func ParseGitHTTPError(statusCode int, body string) error {
+	var appErr AppError
+	appErr.StatusCode = statusCode
+
+	switch statusCode {
+	case 401, 403:
+		appErr.Err = ErrAuthenticationRequired
+		appErr.Message = "Authentication required to access this repository"
+		appErr.Hint = "Use --token flag to provide a token with appropriate permissions"
+
+	case 404:
+		appErr.Err = ErrRepositoryNotFound
+		appErr.Message = "Repository not found"
+		appErr.Hint = "Check that the repository URL is correct"
+
+	default:
+		appErr.Err = ErrGitFetchFailed
+		appErr.Message = fmt.Sprintf("Git server error (%d)", statusCode)
+		if body != "" {
+			appErr.Hint = fmt.Sprintf("Server response: %s", body)
+		}
+	}
+
+	return &appErr
+}
@@ -1,8 +1,11 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 )
@@ -19,93 +22,74 @@ func FileExists(path string) bool {
 	return !info.IsDir()
 }
 
+// SaveToFile writes content to a temporary file next to path and renames it
+// into place once complete, so a failed or interrupted write never leaves a
+// partial file behind.
 func SaveToFile(path string, content io.Reader) error {
-	dir := filepath.Dir(path)
-	if err := EnsureDir(dir); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", dir, err)
-	}
-
-	file, err := os.Create(path)
+	tempPath, err := SaveToTempFile(path, content)
 	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", path, err)
+		return err
 	}
-	defer file.Close()
+	defer os.Remove(tempPath)
 
-	_, err = io.Copy(file, content)
-	if err != nil {
+	if err := os.Rename(tempPath, path); err != nil {
 		return fmt.Errorf("failed to write to file %s: %w", path, err)
 	}
 
 	return nil
 }
 
-func CopyDirectory(src, dst string) error {
-	srcInfo, err := os.Stat(src)
-	if err != nil {
-		return fmt.Errorf("failed to stat source directory: %w", err)
-	}
-
-	if err := EnsureDir(dst); err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
-	}
-
-	if err := os.Chmod(dst, srcInfo.Mode()); err != nil {
-		return fmt.Errorf("failed to set permissions on destination directory: %w", err)
+// SaveToTempFile writes content to a temporary file next to path and returns
+// its name, leaving the rename into place to the caller.
+func SaveToTempFile(path string, content io.Reader) (string, error) {
+	dir := filepath.Dir(path)
+	if err := EnsureDir(dir); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 
-	entries, err := os.ReadDir(src)
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.part")
 	if err != nil {
-		return fmt.Errorf("failed to read source directory: %w", err)
-	}
-
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
-
-		if entry.IsDir() {
-			if err := CopyDirectory(srcPath, dstPath); err != nil {
-				return err
-			}
-		} else {
-			if err := CopyFile(srcPath, dstPath); err != nil {
-				return err
-			}
-		}
+		return "", fmt.Errorf("failed to create file %s: %w", path, err)
 	}
+	tempPath := file.Name()
 
-	return nil
-}
-
-func CopyFile(src, dst string) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("failed to open source file: %w", err)
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to write to file %s: %w", path, err)
 	}
-	defer srcFile.Close()
 
-	srcInfo, err := srcFile.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to stat source file: %w", err)
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to write to file %s: %w", path, err)
 	}
 
-	dstDir := filepath.Dir(dst)
-	if err := EnsureDir(dstDir); err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
+	if err := os.Chmod(tempPath, 0644); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to set permissions on file %s: %w", path, err)
 	}
 
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
-	}
-	defer dstFile.Close()
+	return tempPath, nil
+}
 
-	if _, err = io.Copy(dstFile, srcFile); err != nil {
-		return fmt.Errorf("failed to copy file content: %w", err)
+// SymlinkToTemp creates a link to target under a temporary name next to path
+// and returns that name, leaving the rename into place to the caller.
+func SymlinkToTemp(target, path string) (string, error) {
+	dir := filepath.Dir(path)
+	if err := EnsureDir(dir); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 
-	if err := os.Chmod(dst, srcInfo.Mode()); err != nil {
-		return fmt.Errorf("failed to set permissions on destination file: %w", err)
+	// Names are picked like os.CreateTemp does, retrying when taken.
+	var err error
+	for try := 0; try < 10000; try++ {
+		tempPath := filepath.Join(dir, fmt.Sprintf(".%s.%d.part", filepath.Base(path), rand.Uint32()))
+		if err = os.Symlink(target, tempPath); !errors.Is(err, fs.ErrExist) {
+			if err != nil {
+				break
+			}
+			return tempPath, nil
+		}
 	}
-
-	return nil
+	return "", fmt.Errorf("failed to create link %s: %w", path, err)
 }
@@ -2,6 +2,7 @@ package util
 
 import (
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -33,3 +34,71 @@ func NewGitHubRequest(method, url string, token string) (*http.Request, error) {
 
 	return req, nil
 }
+
+func NewGitLabRequest(method, url string, token string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	if token != "" {
+		req.Header.Set("PRIVATE-TOKEN", token)
+	}
+
+	return req, nil
+}
+
+func NewGiteaRequest(method, url string, token string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	return req, nil
+}
+
+// NewBitbucketRequest authenticates with HTTP basic auth when the token has the
+// "username:app_password" form and with a bearer token otherwise.
+func NewBitbucketRequest(method, url string, token string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+
+	if username, password, ok := strings.Cut(token, ":"); ok {
+		req.SetBasicAuth(username, password)
+	} else if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+// NewAzureDevOpsRequest authenticates with a personal access token, which
+// Azure DevOps expects as the password of a basic auth pair.
+func NewAzureDevOpsRequest(method, url string, token string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+
+	if token != "" {
+		req.SetBasicAuth("", token)
+	}
+
+	return req, nil
+}